@@ -2,41 +2,125 @@ package googleclouddns
 
 import (
 	"context"
-	"time"
+	"slices"
 
 	"github.com/libdns/libdns"
 	"google.golang.org/api/dns/v1"
 )
 
-// deleteCloudDNSRecord will delete the specified record set.
-func (p *Provider) deleteCloudDNSRecord(ctx context.Context, zone, name, recordType string, recordsToDelete, existingRecords libdnsRecords) (libdnsRecords, error) {
-	if err := p.newService(ctx); err != nil {
-		return nil, err
+// deleteCloudDNSRecords removes the records that exist from the record sets of the zone, served by the
+// gcdZone managed zone, and returns the records that were deleted along with the ID of the pending
+// change. Records without a type, TTL or data delete the records of any type, TTL or data at their name.
+func (p *Provider) deleteCloudDNSRecords(ctx context.Context, zone, gcdZone string, records []libdns.Record) ([]libdns.Record, string, error) {
+	change := &dns.Change{}
+	deletedRecords := make(libdnsRecords, 0)
+	records, err := p.expandCloudDNSRecordTypes(ctx, zone, gcdZone, records)
+	if err != nil {
+		return nil, "", err
+	}
+	groups := libdnsRecords(records).groupRecordsByType()
+	existing, err := p.getExistingCloudDNSRecordSets(ctx, zone, gcdZone, groups)
+	if err != nil {
+		return nil, "", err
+	}
+	for i, group := range groups {
+		existingRecordSet, existingRecords := existing[i].recordSet, existing[i].records
+		if existingRecordSet == nil { // If the entry does not exist, nothing to delete so skip this set
+			continue
+		}
+		verifiedRecords := make(libdnsRecords, 0)
+		for _, recordToDelete := range group.records { // Make sure the requested records exist in the Cloud DNS record
+			for _, match := range existingRecords.matchingRecords(recordToDelete) {
+				if verifiedRecords.doesNotHaveRecord(match) {
+					verifiedRecords = append(verifiedRecords, match)
+				}
+			}
+		}
+		if len(verifiedRecords) == 0 { // The Cloud DNS entry does not have these records so skip this set
+			continue
+		}
+		if err := stageCloudDNSDeletion(change, zone, existingRecordSet, verifiedRecords, existingRecords); err != nil {
+			return nil, "", err
+		}
+		deletedRecords = append(deletedRecords, verifiedRecords...)
 	}
-	fullName := libdns.AbsoluteName(name, zone)
-	gcdZone, err := p.getCloudDNSZone(zone)
+	_, changeID, err := p.postCloudDNSChange(ctx, zone, gcdZone, change)
+	if err != nil {
+		return nil, "", err
+	}
+	return deletedRecords, changeID, nil
+}
+
+// expandCloudDNSRecordTypes replaces each record to delete without a type by one record for each
+// type of record set at its name. Each name is listed once, up to Workers names at the same time. The
+// SOA and NS record sets at the zone apex are left out, as they cannot be deleted.
+func (p *Provider) expandCloudDNSRecordTypes(ctx context.Context, zone, gcdZone string, records []libdns.Record) ([]libdns.Record, error) {
+	names := make([]string, 0)
+	for _, record := range records {
+		if rr := record.RR(); rr.Type == "" && !slices.Contains(names, rr.Name) {
+			names = append(names, rr.Name)
+		}
+	}
+	if len(names) == 0 {
+		return records, nil
+	}
+	recordSetsByName, err := p.getCloudDNSRecordSetsByNames(ctx, zone, gcdZone, names)
 	if err != nil {
 		return nil, err
 	}
-	updatedRecordList := make(libdnsRecords, 0) // a list of records, if any, to keep for the Cloud DNS entry
-	for _, record := range existingRecords {
-		if recordsToDelete.doesNotHaveRecord(record) {
-			updatedRecordList = append(updatedRecordList, record)
+	expanded := make([]libdns.Record, 0, len(records))
+	for _, record := range records {
+		rr := record.RR()
+		if rr.Type != "" {
+			expanded = append(expanded, record)
 			continue
 		}
+		for _, recordSet := range recordSetsByName[slices.Index(names, rr.Name)] {
+			if recordSet.Type == "SOA" || (recordSet.Type == "NS" && libdns.RelativeName(recordSet.Name, zone) == "@") {
+				continue
+			}
+			typed := rr
+			typed.Type = recordSet.Type
+			expanded = append(expanded, typed)
+		}
 	}
-	if len(updatedRecordList) == 0 { // No records left with Cloud DNS entry, delete the whole thing
-		_, err = p.service.ResourceRecordSets.Delete(p.Project, gcdZone, fullName, recordType).Context(ctx).Do()
-		return recordsToDelete, err
+	return expanded, nil
+}
+
+// stageCloudDNSDeletion adds the removal of the specified records to the change. If records are left
+// in the Cloud DNS record set, it is replaced by one holding the remaining records. For a record set with
+// a routing policy, only the items of the RoutingPolicy records to delete are removed from the policy.
+func stageCloudDNSDeletion(change *dns.Change, zone string, existingRecordSet *dns.ResourceRecordSet, recordsToDelete, existingRecords libdnsRecords) error {
+	updatedRecordList := make(libdnsRecords, 0) // a list of records, if any, to keep for the Cloud DNS entry
+	if existingRecordSet.RoutingPolicy != nil {
+		policy := existingRecordSet.RoutingPolicy
+		for _, record := range recordsToDelete {
+			if routingPolicy, ok := record.(RoutingPolicy); ok {
+				policy = removeRoutingPolicy(policy, routingPolicy.Policy)
+			}
+		}
+		if policy != nil {
+			updatedRecordList = append(updatedRecordList, RoutingPolicy{
+				Name:   existingRecords[0].RR().Name,
+				Type:   existingRecordSet.Type,
+				TTL:    existingRecords[0].RR().TTL,
+				Policy: policy,
+			})
+		}
+	} else {
+		for _, record := range existingRecords {
+			if recordsToDelete.doesNotHaveRecord(record) {
+				updatedRecordList = append(updatedRecordList, record)
+			}
+		}
 	}
-	// Let's patch the existing entry with the records left
-	rrs := dns.ResourceRecordSet{
-		Name:    fullName,
-		Rrdatas: make([]string, 0),
-		Ttl:     int64(updatedRecordList[0].RR().TTL / time.Second),
-		Type:    recordType,
+	change.Deletions = append(change.Deletions, existingRecordSet)
+	if len(updatedRecordList) > 0 { // Let's put back the records left
+		rrs, err := updatedRecordList.toResourceRecordSet(zone)
+		if err != nil {
+			return err
+		}
+		change.Additions = append(change.Additions, rrs)
 	}
-	rrs.Rrdatas = updatedRecordList.prepValuesForCloudDNS()
-	_, err = p.service.ResourceRecordSets.Patch(p.Project, gcdZone, rrs.Name, rrs.Type, &rrs).Context(ctx).Do()
-	return recordsToDelete, err
+	return nil
 }
@@ -2,7 +2,8 @@ package googleclouddns
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"sync"
 
 	"github.com/libdns/libdns"
 	"google.golang.org/api/dns/v1"
@@ -14,24 +15,28 @@ func (p *Provider) getCloudDNSRecords(ctx context.Context, zone string) ([]libdn
 	if err := p.newService(ctx); err != nil {
 		return nil, err
 	}
+	ctx = p.withRetryBudget(ctx)
 
-	gcdZone, err := p.getCloudDNSZone(zone)
+	gcdZone, err := p.getCloudDNSZone(ctx, zone)
 	if err != nil {
 		return nil, err
 	}
-	rrsReq := p.service.ResourceRecordSets.List(p.Project, gcdZone)
-	records := make([]libdns.Record, 0)
-	if err := rrsReq.Pages(ctx, func(page *dns.ResourceRecordSetsListResponse) error {
-		for _, googleRecord := range page.Rrsets {
-			convertedRecords, err := convertToLibDNS(googleRecord, zone)
-			if err != nil {
-				return fmt.Errorf("error converting to libdns records: %w", err)
+	records, err := retry(ctx, p, isTransientError, func() ([]libdns.Record, error) {
+		rrsReq := p.service.ResourceRecordSets.List(p.Project, gcdZone)
+		records := make([]libdns.Record, 0)
+		err := rrsReq.Pages(ctx, func(page *dns.ResourceRecordSetsListResponse) error {
+			for _, googleRecord := range page.Rrsets {
+				if !isInZone(googleRecord.Name, zone) { // the zone is a subdomain of the managed zone
+					continue
+				}
+				records = append(records, p.convertRecordSet(googleRecord, zone)...)
 			}
-			records = append(records, convertedRecords...)
-		}
-		return nil
-	}); err != nil {
-		return nil, err
+			return nil
+		})
+		return records, err
+	})
+	if err != nil {
+		return nil, p.forgetDeletedZone(wrapGoogleError(err, ErrZoneNotFound))
 	}
 	return records, nil
 }
@@ -42,14 +47,148 @@ func (p *Provider) getCloudDNSRecord(ctx context.Context, zone, name, recordType
 	if err := p.newService(ctx); err != nil {
 		return nil, err
 	}
-	gcdZone, err := p.getCloudDNSZone(zone)
+	gcdZone, err := p.getCloudDNSZone(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := p.getCloudDNSRecordSet(ctx, zone, gcdZone, name, recordType)
 	if err != nil {
 		return nil, err
 	}
+	return p.convertRecordSet(rrs, zone), nil
+}
+
+// getCloudDNSRecordSet returns the Cloud DNS record set for the specified zone, name, and type as it
+// is stored by Google in the managed zone serving the zone. This is needed when the record set has to
+// be removed as part of a change.
+func (p *Provider) getCloudDNSRecordSet(ctx context.Context, zone, gcdZone, name, recordType string) (*dns.ResourceRecordSet, error) {
+	fullName := libdns.AbsoluteName(name, zone)
+	rrs, err := retry(ctx, p, isTransientError, func() (*dns.ResourceRecordSet, error) {
+		return p.service.ResourceRecordSets.Get(p.Project, gcdZone, fullName, recordType).Context(ctx).Do()
+	})
+	if err != nil {
+		return nil, wrapGoogleError(err, ErrRecordNotFound)
+	}
+	return rrs, nil
+}
+
+// getCloudDNSRecordSetsByName returns the Cloud DNS record sets of every type for the specified zone
+// and name, from the managed zone serving the zone.
+func (p *Provider) getCloudDNSRecordSetsByName(ctx context.Context, zone, gcdZone, name string) ([]*dns.ResourceRecordSet, error) {
 	fullName := libdns.AbsoluteName(name, zone)
-	rrs, err := p.service.ResourceRecordSets.Get(p.Project, gcdZone, fullName, recordType).Context(ctx).Do()
+	recordSets, err := retry(ctx, p, isTransientError, func() ([]*dns.ResourceRecordSet, error) {
+		recordSets := make([]*dns.ResourceRecordSet, 0)
+		err := p.service.ResourceRecordSets.List(p.Project, gcdZone).Name(fullName).Pages(ctx, func(page *dns.ResourceRecordSetsListResponse) error {
+			recordSets = append(recordSets, page.Rrsets...)
+			return nil
+		})
+		return recordSets, err
+	})
+	if err != nil {
+		return nil, wrapGoogleError(err, ErrZoneNotFound)
+	}
+	return recordSets, nil
+}
+
+// getExistingCloudDNSRecords returns the Cloud DNS record set for the specified zone, name, and type along
+// with its libdns.Records. If the record set does not exist, no record set and no records are returned.
+func (p *Provider) getExistingCloudDNSRecords(ctx context.Context, zone, gcdZone, name, recordType string) (*dns.ResourceRecordSet, libdnsRecords, error) {
+	rrs, err := p.getCloudDNSRecordSet(ctx, zone, gcdZone, name, recordType)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return rrs, p.convertRecordSet(rrs, zone), nil
+}
+
+// existingRecordSet is a Cloud DNS record set along with its libdns.Records. Both are nil if the record
+// set does not exist.
+type existingRecordSet struct {
+	recordSet *dns.ResourceRecordSet
+	records   libdnsRecords
+}
+
+// getExistingCloudDNSRecordSets returns the existing Cloud DNS record set of each group, in the same order
+// as the groups. Up to Workers record sets are read at the same time, and the first error stops the reads.
+func (p *Provider) getExistingCloudDNSRecordSets(ctx context.Context, zone, gcdZone string, groups []recordGroup) ([]existingRecordSet, error) {
+	existing := make([]existingRecordSet, len(groups))
+	err := p.readConcurrently(ctx, len(groups), func(ctx context.Context, i int) error {
+		rrs, records, err := p.getExistingCloudDNSRecords(ctx, zone, gcdZone, groups[i].name, groups[i].recordType)
+		existing[i] = existingRecordSet{recordSet: rrs, records: records}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// getCloudDNSRecordSetsByNames returns the Cloud DNS record sets of every type at each of the names,
+// in the same order as the names. Up to Workers names are listed at the same time, and the first error
+// stops the listings.
+func (p *Provider) getCloudDNSRecordSetsByNames(ctx context.Context, zone, gcdZone string, names []string) ([][]*dns.ResourceRecordSet, error) {
+	recordSets := make([][]*dns.ResourceRecordSet, len(names))
+	err := p.readConcurrently(ctx, len(names), func(ctx context.Context, i int) error {
+		var err error
+		recordSets[i], err = p.getCloudDNSRecordSetsByName(ctx, zone, gcdZone, names[i])
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return convertToLibDNS(rrs, zone)
+	return recordSets, nil
+}
+
+// readConcurrently calls read with the index of each of the count reads, running up to Workers of them
+// at the same time. Once a read fails no other read is started, the running ones are cancelled and the
+// first error is returned.
+func (p *Provider) readConcurrently(ctx context.Context, count int, read func(context.Context, int) error) error {
+	workers := p.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	semaphore := make(chan struct{}, workers)
+	for i := range count {
+		semaphore <- struct{}{}
+		if ctx.Err() != nil { // a read failed, no need to start the others
+			<-semaphore
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if err := read(ctx, i); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// convertRecordSet converts the Cloud DNS record set into libdns.Records. Values that cannot be parsed
+// are kept as a libdns.RR and a warning is logged, so one odd record set does not break the whole zone.
+func (p *Provider) convertRecordSet(googleRecord *dns.ResourceRecordSet, zone string) libdnsRecords {
+	records, warnings := convertToLibDNS(googleRecord, zone)
+	for _, warning := range warnings {
+		p.logger().Warn("unable to fully convert Cloud DNS record set",
+			"name", googleRecord.Name, "type", googleRecord.Type, "error", warning)
+	}
+	return records
 }
@@ -2,46 +2,220 @@ package googleclouddns
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/libdns/libdns"
 	"google.golang.org/api/dns/v1"
-	"google.golang.org/api/googleapi"
 )
 
-// postCloudDNSRecord will attempt to create a new Google Cloud DNS record set based on the libdns.Records or patch an existing one.
-func (p *Provider) postCloudDNSRecord(ctx context.Context, zone string, recordsToSend libdnsRecords) (libdnsRecords, error) {
+// maxClockSkew is the difference tolerated between the local time and the start time of the changes
+// reported by Cloud DNS.
+const maxClockSkew = time.Minute
+
+// errStopListing stops listing the changes of a managed zone early.
+var errStopListing = errors.New("stop listing")
+
+// stageCloudDNSRecord adds the records to the change as a single Cloud DNS record set. If a record set
+// already exists for the name and type, it is removed in the same change so the new one replaces it.
+func stageCloudDNSRecord(change *dns.Change, zone string, existingRecordSet *dns.ResourceRecordSet, recordsToSend libdnsRecords) error {
+	rrs, err := recordsToSend.toResourceRecordSet(zone)
+	if err != nil {
+		return err
+	}
+	if existingRecordSet != nil {
+		change.Deletions = append(change.Deletions, existingRecordSet)
+	}
+	change.Additions = append(change.Additions, rrs)
+	return nil
+}
+
+// changeCloudDNSRecords applies the records to the zone with the change function while holding the
+// lock of its managed zone. The change is reapplied if it conflicts with a concurrent one, and its calls
+// to Cloud DNS share a single retry budget with the reapplications. Once the change is submitted the
+// lock is released before waiting for the change to propagate, if WaitForPropagation is set. Only the
+// change submitted is waited for, not the other pending changes of the zone. The change function is
+// passed the managed zone resolved and locked here, so its calls all use the same managed zone.
+func (p *Provider) changeCloudDNSRecords(ctx context.Context, zone string, records []libdns.Record,
+	change func(context.Context, string, string, []libdns.Record) ([]libdns.Record, string, error)) ([]libdns.Record, error) {
 	if err := p.newService(ctx); err != nil {
 		return nil, err
 	}
-	gcdZone, err := p.getCloudDNSZone(zone)
+	retryCtx := p.withRetryBudget(ctx)
+	gcdZone, err := p.getCloudDNSZone(retryCtx, zone)
 	if err != nil {
 		return nil, err
 	}
-	if len(recordsToSend) == 0 {
-		return nil, fmt.Errorf("no records available to add to zone %s", zone)
+	unlock := p.lockZone(gcdZone)
+	var changeID string
+	changedRecords, err := retry(retryCtx, p, isConflictError, func() ([]libdns.Record, error) {
+		changedRecords, id, err := change(retryCtx, zone, gcdZone, records)
+		changeID = id
+		return changedRecords, err
+	})
+	unlock()
+	if err != nil {
+		return changedRecords, p.forgetDeletedZone(err)
+	}
+	if !p.WaitForPropagation || changeID == "" {
+		return changedRecords, nil
+	}
+	ctx, cancel := p.withPropagationTimeout(ctx)
+	defer cancel()
+	return changedRecords, p.waitForCloudDNSChange(ctx, zone, pendingChange{managedZone: gcdZone, id: changeID})
+}
+
+// appendCloudDNSRecords adds the records to the record sets of the zone, served by the gcdZone managed
+// zone, skipping the records that already exist, and returns the records that were added along with
+// the ID of the pending change.
+func (p *Provider) appendCloudDNSRecords(ctx context.Context, zone, gcdZone string, records []libdns.Record) ([]libdns.Record, string, error) {
+	change := &dns.Change{}
+	pendingRecords := make(libdnsRecords, 0)
+	groups := libdnsRecords(records).groupRecordsByType()
+	existing, err := p.getExistingCloudDNSRecordSets(ctx, zone, gcdZone, groups)
+	if err != nil {
+		return nil, "", err
+	}
+	for i, group := range groups {
+		existingRecordSet, existingRecords := existing[i].recordSet, existing[i].records
+		verifiedNewRecords := make(libdnsRecords, 0)
+		for _, newRecord := range group.records { // Make sure that we do not append a record that already exists
+			if existingRecords.doesNotHaveRecord(newRecord) {
+				verifiedNewRecords = append(verifiedNewRecords, newRecord)
+			}
+		}
+		if len(verifiedNewRecords) == 0 {
+			continue
+		}
+		if err := stageCloudDNSRecord(change, zone, existingRecordSet, append(existingRecords, verifiedNewRecords...)); err != nil {
+			return nil, "", err
+		}
+		pendingRecords = append(pendingRecords, verifiedNewRecords...)
+	}
+	submittedRecords, changeID, err := p.postCloudDNSChange(ctx, zone, gcdZone, change)
+	if err != nil {
+		return nil, "", err
+	}
+	// Let's generate an exact list of appended records based on the returned results
+	processedRecords := make(libdnsRecords, 0)
+	for _, updatedRecord := range submittedRecords {
+		if pendingRecords.hasRecord(updatedRecord) {
+			processedRecords = append(processedRecords, updatedRecord)
+		}
+	}
+	// Routing policies are merged into the existing ones, so report the appended policies themselves
+	for _, pendingRecord := range pendingRecords {
+		if _, ok := pendingRecord.(RoutingPolicy); ok && submittedRecords.hasRecord(pendingRecord) {
+			processedRecords = append(processedRecords, pendingRecord)
+		}
+	}
+	return processedRecords, changeID, nil
+}
+
+// setCloudDNSRecords replaces the record sets of the zone, served by the gcdZone managed zone, with the
+// records, skipping the record sets that are already up to date, and returns the records of the
+// updated record sets along with the ID of the pending change. A record set with a routing policy is only replaced by RoutingPolicy records, so
+// plain records never wipe its policy.
+func (p *Provider) setCloudDNSRecords(ctx context.Context, zone, gcdZone string, records []libdns.Record) ([]libdns.Record, string, error) {
+	change := &dns.Change{}
+	unchangedRecords := make(libdnsRecords, 0)
+	groups := libdnsRecords(records).groupRecordsByType()
+	existing, err := p.getExistingCloudDNSRecordSets(ctx, zone, gcdZone, groups)
+	if err != nil {
+		return nil, "", err
 	}
-	recordToSend := recordsToSend[0].RR()
-	name := recordToSend.Name
-	fullName := libdns.AbsoluteName(name, zone)
-	rrs := dns.ResourceRecordSet{
-		Name:    fullName,
-		Rrdatas: make([]string, 0),
-		Ttl:     int64(recordToSend.TTL / time.Second),
-		Type:    recordToSend.Type,
+	for i, group := range groups {
+		existingRecordSet, existingRecords := existing[i].recordSet, existing[i].records
+		if existingRecords.isEquivalent(group.records) { // Cloud DNS is already up to date for this set
+			unchangedRecords = append(unchangedRecords, existingRecords...)
+			continue
+		}
+		if existingRecordSet != nil && existingRecordSet.RoutingPolicy != nil && !slices.ContainsFunc(group.records, isRoutingPolicy) {
+			return nil, "", fmt.Errorf("record set %s (%s) has a routing policy, which plain records cannot replace", group.name, group.recordType)
+		}
+		if err := stageCloudDNSRecord(change, zone, existingRecordSet, group.records); err != nil {
+			return nil, "", err
+		}
 	}
-	rrs.Rrdatas = recordsToSend.prepValuesForCloudDNS()
-	googleRecord, err := p.service.ResourceRecordSets.Create(p.Project, gcdZone, &rrs).Context(ctx).Do()
+	submittedRecords, changeID, err := p.postCloudDNSChange(ctx, zone, gcdZone, change)
 	if err != nil {
-		if gErr, ok := err.(*googleapi.Error); !ok || gErr.Code != 409 {
-			return nil, err
+		return nil, "", err
+	}
+	return append(submittedRecords, unchangedRecords...), changeID, nil
+}
+
+// postCloudDNSChange submits all the additions and deletions as a single Cloud DNS change, so
+// either every record set is updated or none are. It returns the records that were added along with
+// the ID of the change, which is empty if the change is already done. As submitting a change is not
+// idempotent, a change that failed is only submitted again if it was not applied.
+func (p *Provider) postCloudDNSChange(ctx context.Context, zone, gcdZone string, change *dns.Change) (libdnsRecords, string, error) {
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		return nil, "", nil
+	}
+	submitted, attempted := time.Now(), false
+	submittedChange, err := retry(ctx, p, isTransientError, func() (*dns.Change, error) {
+		if attempted { // the change may have been applied even though submitting it failed
+			if appliedChange, err := p.findCloudDNSChange(ctx, zone, gcdZone, change, submitted); err != nil || appliedChange != nil {
+				return appliedChange, err
+			}
+		}
+		attempted = true
+		return p.service.Changes.Create(p.Project, gcdZone, change).Context(ctx).Do()
+	})
+	if err != nil { // a record set to delete missing means it was changed by someone else in the meantime
+		return nil, "", wrapGoogleError(err, ErrConflict)
+	}
+	p.trackCloudDNSChange(zone, gcdZone, submittedChange)
+	addedRecords := make(libdnsRecords, 0)
+	for _, googleRecord := range submittedChange.Additions {
+		addedRecords = append(addedRecords, p.convertRecordSet(googleRecord, zone)...)
+	}
+	if submittedChange.Status == changeStatusDone {
+		return addedRecords, "", nil
+	}
+	return addedRecords, submittedChange.Id, nil
+}
+
+// findCloudDNSChange returns the change of the managed zone holding the same deletions and additions
+// as the change, among the changes started since the specified time, or nil if there is none.
+func (p *Provider) findCloudDNSChange(ctx context.Context, zone, gcdZone string, change *dns.Change, since time.Time) (*dns.Change, error) {
+	var appliedChange *dns.Change
+	changesLister := p.service.Changes.List(p.Project, gcdZone).SortBy("changeSequence").SortOrder("descending")
+	err := changesLister.Pages(ctx, func(page *dns.ChangesListResponse) error {
+		for _, submittedChange := range page.Changes {
+			if startTime, err := time.Parse(time.RFC3339, submittedChange.StartTime); err == nil && startTime.Before(since.Add(-maxClockSkew)) {
+				return errStopListing // older changes cannot be ours
+			}
+			if sameRecordSets(submittedChange.Deletions, change.Deletions, zone) && sameRecordSets(submittedChange.Additions, change.Additions, zone) {
+				appliedChange = submittedChange
+				return errStopListing
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopListing) {
+		return nil, err
+	}
+	return appliedChange, nil
+}
+
+// sameRecordSets returns true if both lists hold the same record sets in the same order, comparing
+// their values the way Cloud DNS stores them.
+func sameRecordSets(a, b []*dns.ResourceRecordSet, zone string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Type != b[i].Type {
+			return false
 		}
-		// Record exists and we'd really like to get this libdns.Record into the zone so how about we try patching it instead...
-		googleRecord, err = p.service.ResourceRecordSets.Patch(p.Project, gcdZone, rrs.Name, rrs.Type, &rrs).Context(ctx).Do()
-		if err != nil {
-			return nil, err
+		aRecords, _ := convertToLibDNS(a[i], zone)
+		bRecords, _ := convertToLibDNS(b[i], zone)
+		if !aRecords.isEquivalent(bRecords) {
+			return false
 		}
 	}
-	return convertToLibDNS(googleRecord, zone)
+	return true
 }
@@ -0,0 +1,141 @@
+package googleclouddns
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"google.golang.org/api/dns/v1"
+)
+
+const (
+	// defaultPropagationTimeout limits the wait for changes if PropagationTimeout is not set
+	defaultPropagationTimeout = time.Minute * 5
+	// changeStatusDone is the status of a change once it is applied to the authoritative servers
+	changeStatusDone = "done"
+	// maxPendingChanges is the number of pending changes tracked per zone, older ones are forgotten
+	// so the provider does not grow if WaitForChanges is never called
+	maxPendingChanges = 100
+)
+
+// changePollInterval is the time between two reads of the status of the pending changes.
+var changePollInterval = time.Second * 2
+
+// pendingChange is a change submitted to a managed zone that was not applied yet.
+type pendingChange struct {
+	managedZone string
+	id          string
+}
+
+// trackCloudDNSChange remembers the submitted change until it is done, so WaitForChanges can wait
+// for it.
+func (p *Provider) trackCloudDNSChange(zone, managedZone string, change *dns.Change) {
+	if change.Status == changeStatusDone {
+		return
+	}
+	p.pendingChangesMutex.Lock()
+	defer p.pendingChangesMutex.Unlock()
+	if p.pendingChanges == nil {
+		p.pendingChanges = make(map[string][]pendingChange)
+	}
+	pending := append(p.pendingChanges[zone], pendingChange{managedZone: managedZone, id: change.Id})
+	if len(pending) > maxPendingChanges {
+		pending = pending[len(pending)-maxPendingChanges:]
+	}
+	p.pendingChanges[zone] = pending
+}
+
+// getPendingCloudDNSChanges returns the changes submitted to the zone that were not applied yet.
+func (p *Provider) getPendingCloudDNSChanges(zone string) []pendingChange {
+	p.pendingChangesMutex.Lock()
+	defer p.pendingChangesMutex.Unlock()
+	return slices.Clone(p.pendingChanges[zone])
+}
+
+// forgetCloudDNSChange stops tracking the change once it is done.
+func (p *Provider) forgetCloudDNSChange(zone string, change pendingChange) {
+	p.pendingChangesMutex.Lock()
+	defer p.pendingChangesMutex.Unlock()
+	p.pendingChanges[zone] = slices.DeleteFunc(p.pendingChanges[zone], func(c pendingChange) bool {
+		return c == change
+	})
+	if len(p.pendingChanges[zone]) == 0 {
+		delete(p.pendingChanges, zone)
+	}
+}
+
+// withPropagationTimeout limits the wait for changes to the PropagationTimeout.
+func (p *Provider) withPropagationTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := p.PropagationTimeout
+	if timeout <= 0 {
+		timeout = defaultPropagationTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// isCloudDNSChangeDone reads the status of the pending change, and stops tracking it once it is done.
+func (p *Provider) isCloudDNSChangeDone(ctx context.Context, zone string, change pendingChange) (bool, error) {
+	submittedChange, err := retry(ctx, p, isTransientError, func() (*dns.Change, error) {
+		return p.service.Changes.Get(p.Project, change.managedZone, change.id).Context(ctx).Do()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, fmt.Errorf("changes to zone %s are still pending: %w", zone, ctx.Err())
+		}
+		return false, wrapGoogleError(err, nil)
+	}
+	if submittedChange.Status != changeStatusDone {
+		return false, nil
+	}
+	p.forgetCloudDNSChange(zone, change)
+	return true, nil
+}
+
+// waitForCloudDNSChange polls the status of the pending change until it is done, or ctx is done.
+func (p *Provider) waitForCloudDNSChange(ctx context.Context, zone string, change pendingChange) error {
+	for {
+		done, err := p.isCloudDNSChangeDone(ctx, zone, change)
+		if err != nil || done {
+			return err
+		}
+		if err := sleepChangePollInterval(ctx, zone); err != nil {
+			return err
+		}
+	}
+}
+
+// waitForCloudDNSChanges polls the status of the pending changes of the zone until all of them are
+// done, or ctx is done. Changes that no longer exist, e.g. as their managed zone was deleted, are
+// forgotten instead of failing every later wait.
+func (p *Provider) waitForCloudDNSChanges(ctx context.Context, zone string) error {
+	for {
+		for _, change := range p.getPendingCloudDNSChanges(zone) {
+			_, err := p.isCloudDNSChangeDone(ctx, zone, change)
+			if isNotFoundError(err) {
+				p.forgetCloudDNSChange(zone, change)
+			} else if err != nil {
+				return err
+			}
+		}
+		if len(p.getPendingCloudDNSChanges(zone)) == 0 {
+			return nil
+		}
+		if err := sleepChangePollInterval(ctx, zone); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepChangePollInterval waits for the next poll of the pending changes of the zone, or until ctx
+// is done.
+func sleepChangePollInterval(ctx context.Context, zone string) error {
+	timer := time.NewTimer(changePollInterval)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return fmt.Errorf("changes to zone %s are still pending: %w", zone, ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
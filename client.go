@@ -3,52 +3,258 @@ package googleclouddns
 import (
 	"context"
 	"fmt"
-	"time"
+	"log/slog"
+	"strings"
+	"sync"
 
 	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
 )
 
 const (
-	// zoneMapTTL timeout for the Google Cloud DNS zone map
-	zoneMapTTL = time.Minute * 5
+	// defaultWorkers is the number of record sets read at the same time if Workers is not set
+	defaultWorkers = 8
 )
 
-// newService initializes the Google client for the provider using the specified JSON file for credentials if set.
+// Zone visibilities supported by Google Cloud DNS, used by Provider.ZoneVisibility and
+// Provider.PreferredVisibility.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+	// VisibilityAll allows both public and private zones to be used.
+	VisibilityAll = "all"
+)
+
+// newService initializes the Google client for the provider. Credentials are taken, in order, from the
+// token source, the service account JSON (either the JSON itself or the path to a JSON file), or the
+// application default credentials. If a service account to impersonate is set, those credentials are
+// used to impersonate it. The endpoint and HTTP client replace the Google defaults when set, and any client
+// options are applied last.
 func (p *Provider) newService(ctx context.Context) error {
+	p.serviceMutex.Lock()
+	defer p.serviceMutex.Unlock()
 	var err error
 	if p.service == nil {
-		scopeOption := option.WithScopes(dns.NdevClouddnsReadwriteScope)
-		if p.ServiceAccountJSON != "" {
-			p.service, err = dns.NewService(ctx, scopeOption, option.WithCredentialsFile(p.ServiceAccountJSON))
-		} else {
-			p.service, err = dns.NewService(ctx, scopeOption)
+		credentialOptions := make([]option.ClientOption, 0)
+		switch {
+		case p.TokenSource != nil:
+			credentialOptions = append(credentialOptions, option.WithTokenSource(p.TokenSource))
+		case strings.HasPrefix(strings.TrimSpace(p.ServiceAccountJSON), "{"):
+			credentialOptions = append(credentialOptions, option.WithCredentialsJSON([]byte(p.ServiceAccountJSON)))
+		case p.ServiceAccountJSON != "":
+			credentialOptions = append(credentialOptions, option.WithCredentialsFile(p.ServiceAccountJSON))
+		}
+		if p.ImpersonateServiceAccount != "" {
+			tokenSource, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+				TargetPrincipal: p.ImpersonateServiceAccount,
+				Delegates:       p.ImpersonateDelegates,
+				Scopes:          []string{dns.NdevClouddnsReadwriteScope},
+			}, credentialOptions...)
+			if err != nil {
+				return err
+			}
+			credentialOptions = []option.ClientOption{option.WithTokenSource(tokenSource)}
+		}
+		options := append([]option.ClientOption{option.WithScopes(dns.NdevClouddnsReadwriteScope)}, credentialOptions...)
+		if p.Endpoint != "" {
+			options = append(options, option.WithEndpoint(p.Endpoint))
+		}
+		if p.HTTPClient != nil {
+			options = append(options, option.WithHTTPClient(p.HTTPClient))
+		}
+		options = append(options, p.ClientOptions...)
+		if p.service, err = dns.NewService(ctx, options...); err != nil {
+			return err
 		}
+		p.service.UserAgent = p.UserAgent
 	}
 	return err
 }
 
-// getCloudDNSZone will return the Google Cloud DNS zone name for the specified zone. The data is cached
-// for five minutes to avoid repeated calls to the GCP API servers.
-func (p *Provider) getCloudDNSZone(zone string) (string, error) {
-	if p.zoneMap == nil || time.Since(p.zoneMapLastUpdated) > zoneMapTTL {
-		p.zoneMap = make(map[string]string)
-		zonesLister := p.service.ManagedZones.List(p.Project)
-		err := zonesLister.Pages(context.Background(), func(response *dns.ManagedZonesListResponse) error {
-			for _, zone := range response.ManagedZones {
-				if zone.Visibility == "public" {
-					p.zoneMap[zone.DnsName] = zone.Name
-				}
-			}
-			return nil
-		})
-		if err != nil {
+// logger returns the logger for the provider's warnings.
+func (p *Provider) logger() *slog.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return slog.Default()
+}
+
+// zoneLock serializes the changes to a managed zone. It counts the calls holding or waiting for it,
+// so it is forgotten once no call uses it.
+type zoneLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockZone serializes the changes to the managed zone, so concurrent calls for the same managed zone do
+// not conflict while calls for other managed zones proceed. It returns the function releasing the lock.
+func (p *Provider) lockZone(managedZone string) func() {
+	p.zoneLocksMutex.Lock()
+	if p.zoneLocks == nil {
+		p.zoneLocks = make(map[string]*zoneLock)
+	}
+	lock, ok := p.zoneLocks[managedZone]
+	if !ok {
+		lock = &zoneLock{}
+		p.zoneLocks[managedZone] = lock
+	}
+	lock.users++
+	p.zoneLocksMutex.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		p.zoneLocksMutex.Lock()
+		defer p.zoneLocksMutex.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(p.zoneLocks, managedZone)
+		}
+	}
+}
+
+// getCloudDNSZone will return the Google Cloud DNS zone name for the specified zone. Zones found in
+// ZoneNames are returned as is, otherwise the managed zones are listed and cached for the ZoneCacheTTL
+// to avoid repeated calls to the GCP API servers. If AutoDiscoverZone is set, the most specific managed
+// zone containing the specified zone is returned.
+func (p *Provider) getCloudDNSZone(ctx context.Context, zone string) (string, error) {
+	if p.AutoDiscoverZone {
+		var err error
+		if zone, err = p.findCloudDNSZone(ctx, zone); err != nil {
 			return "", err
 		}
-		p.zoneMapLastUpdated = time.Now()
 	}
-	if zoneName, ok := p.zoneMap[zone]; ok {
+	if zoneName, ok := p.ZoneNames[zone]; ok {
 		return zoneName, nil
 	}
-	return "", fmt.Errorf("unable to find Google managaged zone for domain %s", zone)
+	zoneMap, err := p.loadZoneMap(ctx, func(zoneMap map[string]string) bool {
+		_, ok := zoneMap[zone]
+		return ok
+	})
+	if err != nil {
+		return "", err
+	}
+	zoneName, ok := zoneMap[zone]
+	if !ok {
+		return "", fmt.Errorf("unable to find Google managaged zone for domain %s: %w", zone, ErrZoneNotFound)
+	}
+	if zoneName == "" {
+		return "", fmt.Errorf("domain %s is served by both a public and a private managed zone, set a preferred visibility", zone)
+	}
+	return zoneName, nil
+}
+
+// findCloudDNSZone returns the DNS name of the most specific zone containing the specified FQDN.
+// Zones found in ZoneNames take precedence over the managed zones listed in the project.
+func (p *Provider) findCloudDNSZone(ctx context.Context, fqdn string) (string, error) {
+	if zone, ok := longestZoneSuffix(fqdn, p.ZoneNames); ok {
+		return zone, nil
+	}
+	zoneMap, err := p.loadZoneMap(ctx, func(zoneMap map[string]string) bool {
+		_, ok := longestZoneSuffix(fqdn, zoneMap)
+		return ok
+	})
+	if err != nil {
+		return "", err
+	}
+	if zone, ok := longestZoneSuffix(fqdn, zoneMap); ok {
+		return zone, nil
+	}
+	return "", fmt.Errorf("unable to find Google managaged zone for domain %s: %w", fqdn, ErrZoneNotFound)
+}
+
+// loadZoneMap returns the DNS names of the cached managed zones mapped to their names. If the zone
+// looked for is not found in the map, the managed zones are listed again in case it was created since
+// they were cached.
+func (p *Provider) loadZoneMap(ctx context.Context, found func(zoneMap map[string]string) bool) (map[string]string, error) {
+	zones, err := p.loadCloudDNSZones(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	zoneMap, err := p.buildZoneMap(zones)
+	if err != nil || found(zoneMap) {
+		return zoneMap, err
+	}
+	if zones, err = p.loadCloudDNSZones(ctx, true); err != nil {
+		return nil, err
+	}
+	return p.buildZoneMap(zones)
+}
+
+// buildZoneMap maps the DNS names of the managed zones the provider can use to their names. Split
+// horizon zones are mapped to the zone of the preferred visibility, or to an empty name without one.
+func (p *Provider) buildZoneMap(zones []*dns.ManagedZone) (map[string]string, error) {
+	visibility, err := p.zoneVisibility()
+	if err != nil {
+		return nil, err
+	}
+	switch p.PreferredVisibility {
+	case "", VisibilityPublic, VisibilityPrivate:
+	default:
+		return nil, fmt.Errorf("unsupported preferred visibility %s", p.PreferredVisibility)
+	}
+	zoneMap := make(map[string]string)
+	for _, zone := range filterCloudDNSZones(zones, visibility) {
+		if _, ok := zoneMap[zone.DnsName]; !ok || zone.Visibility == p.PreferredVisibility {
+			zoneMap[zone.DnsName] = zone.Name
+			continue
+		}
+		if p.PreferredVisibility == "" { // split horizon zone without a preference, we cannot pick one
+			zoneMap[zone.DnsName] = ""
+		}
+	}
+	return zoneMap, nil
+}
+
+// listCloudDNSZones returns the Google Cloud DNS managed zones in the project that can be managed
+// by the provider based on the zone visibility.
+func (p *Provider) listCloudDNSZones(ctx context.Context) ([]*dns.ManagedZone, error) {
+	visibility, err := p.zoneVisibility()
+	if err != nil {
+		return nil, err
+	}
+	zones, err := p.listAllCloudDNSZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterCloudDNSZones(zones, visibility), nil
+}
+
+// listAllCloudDNSZones returns every Google Cloud DNS managed zone in the project, whatever its
+// visibility.
+func (p *Provider) listAllCloudDNSZones(ctx context.Context) ([]*dns.ManagedZone, error) {
+	zones, err := retry(ctx, p, isTransientError, func() ([]*dns.ManagedZone, error) {
+		zones := make([]*dns.ManagedZone, 0)
+		zonesLister := p.service.ManagedZones.List(p.Project)
+		err := zonesLister.Pages(ctx, func(response *dns.ManagedZonesListResponse) error {
+			zones = append(zones, response.ManagedZones...)
+			return nil
+		})
+		return zones, err
+	})
+	if err != nil {
+		return nil, wrapGoogleError(err, nil)
+	}
+	return zones, nil
+}
+
+// zoneVisibility returns the visibility of the managed zones the provider can use.
+func (p *Provider) zoneVisibility() (string, error) {
+	switch p.ZoneVisibility {
+	case "":
+		return VisibilityPublic, nil
+	case VisibilityPublic, VisibilityPrivate, VisibilityAll:
+		return p.ZoneVisibility, nil
+	}
+	return "", fmt.Errorf("unsupported zone visibility %s", p.ZoneVisibility)
+}
+
+// filterCloudDNSZones returns the managed zones of the specified visibility.
+func filterCloudDNSZones(zones []*dns.ManagedZone, visibility string) []*dns.ManagedZone {
+	visibleZones := make([]*dns.ManagedZone, 0, len(zones))
+	for _, zone := range zones {
+		if visibility == VisibilityAll || zone.Visibility == visibility {
+			visibleZones = append(visibleZones, zone)
+		}
+	}
+	return visibleZones
 }
@@ -0,0 +1,76 @@
+package googleclouddns
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// managedZoneParameter names the managed zone in the messages of the Google API errors.
+const managedZoneParameter = "'parameters.managedZone'"
+
+// Errors returned by the provider. They can be checked with errors.Is, and when they come from the
+// Google API, the original *googleapi.Error can still be retrieved with errors.As.
+var (
+	// ErrZoneNotFound is returned when no managed zone can be found for the zone.
+	ErrZoneNotFound = errors.New("zone not found")
+	// ErrRecordNotFound is returned when a record set does not exist in the managed zone.
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrConflict is returned when a change conflicts with the current state of the managed zone,
+	// e.g. a record set was modified by someone else in the meantime.
+	ErrConflict = errors.New("conflicting change")
+	// ErrPermissionDenied is returned when the credentials are missing or are not allowed to
+	// perform the operation.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// wrapGoogleError wraps an error returned by the Google API with the matching provider error. A 404
+// for the managed zone of the call is wrapped with ErrZoneNotFound, any other 404 is wrapped with
+// notFound since what is missing depends on the call, and is left as is if notFound is nil. Other
+// errors are returned unchanged.
+func wrapGoogleError(err error, notFound error) error {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) {
+		return err
+	}
+	switch gErr.Code {
+	case http.StatusNotFound:
+		if isManagedZoneNotFoundError(err) {
+			return fmt.Errorf("%w: %w", ErrZoneNotFound, err)
+		}
+		if notFound != nil {
+			return fmt.Errorf("%w: %w", notFound, err)
+		}
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %w", ErrPermissionDenied, err)
+	}
+	return err
+}
+
+// isManagedZoneNotFoundError returns true if the Google API did not find the managed zone of the call,
+// e.g. as it was deleted after being cached, rather than a record set or a change within it. Cloud
+// DNS reports the missing managed zone as the 'parameters.managedZone' resource.
+func isManagedZoneNotFoundError(err error) bool {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) || gErr.Code != http.StatusNotFound {
+		return false
+	}
+	if strings.Contains(gErr.Message, managedZoneParameter) {
+		return true
+	}
+	return slices.ContainsFunc(gErr.Errors, func(item googleapi.ErrorItem) bool {
+		return strings.Contains(item.Message, managedZoneParameter)
+	})
+}
+
+// isNotFoundError returns true if the Google API did not find what was requested.
+func isNotFoundError(err error) bool {
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && gErr.Code == http.StatusNotFound
+}
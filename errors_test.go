@@ -0,0 +1,118 @@
+package googleclouddns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"google.golang.org/api/googleapi"
+)
+
+func Test_WrapGoogleError(t *testing.T) {
+	tests := []struct {
+		code     int
+		message  string
+		notFound error
+		expected error
+	}{
+		{code: http.StatusNotFound, message: "The 'parameters.managedZone' resource named 'libdns' does not exist.",
+			notFound: ErrConflict, expected: ErrZoneNotFound},
+		{code: http.StatusNotFound, message: "The 'entity.change.deletions[0]' resource named 'www.libdns.io. (A)' does not exist.",
+			notFound: ErrConflict, expected: ErrConflict},
+		{code: http.StatusNotFound, notFound: ErrZoneNotFound, expected: ErrZoneNotFound},
+		{code: http.StatusNotFound, notFound: ErrRecordNotFound, expected: ErrRecordNotFound},
+		{code: http.StatusNotFound},
+		{code: http.StatusConflict, expected: ErrConflict},
+		{code: http.StatusPreconditionFailed, expected: ErrConflict},
+		{code: http.StatusUnauthorized, expected: ErrPermissionDenied},
+		{code: http.StatusForbidden, expected: ErrPermissionDenied},
+		{code: http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.code, test.message, test.notFound), func(t *testing.T) {
+			err := wrapGoogleError(&googleapi.Error{Code: test.code, Message: test.message}, test.notFound)
+			for _, sentinel := range []error{ErrZoneNotFound, ErrRecordNotFound, ErrConflict, ErrPermissionDenied} {
+				if errors.Is(err, sentinel) != (sentinel == test.expected) {
+					t.Fatalf("unexpected match of %v with %v", err, sentinel)
+				}
+			}
+			var gErr *googleapi.Error
+			if !errors.As(err, &gErr) || gErr.Code != test.code {
+				t.Fatal("expected the Google error to be wrapped, received", err)
+			}
+		})
+	}
+	if err := errors.New("not from Google"); wrapGoogleError(err, ErrZoneNotFound) != err {
+		t.Fatal("expected other errors to be returned as is")
+	}
+}
+
+func Test_ProviderErrors(t *testing.T) {
+	t.Run("unknown zones are not found", func(t *testing.T) {
+		p, _ := getFakeDNSClient(t)
+		_, err := p.GetRecords(context.Background(), "example.com.")
+		if !errors.Is(err, ErrZoneNotFound) {
+			t.Fatal("expected a zone not found error back, received", err)
+		}
+		p.ZoneNames = map[string]string{"example.com.": "example-com"}
+		_, err = p.GetRecords(context.Background(), "example.com.")
+		var gErr *googleapi.Error
+		if !errors.Is(err, ErrZoneNotFound) || !errors.As(err, &gErr) || gErr.Code != http.StatusNotFound {
+			t.Fatal("expected a zone not found error wrapping the Google error back, received", err)
+		}
+	})
+	t.Run("deleted zones are not found", func(t *testing.T) {
+		setRetryDelays(t, 100*time.Millisecond, time.Second)
+		p, _ := getFakeDNSClient(t)
+		ctx := context.Background()
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		if err := p.service.ManagedZones.Delete(testProject, "libdns").Do(); err != nil {
+			t.Fatal("error deleting the managed zone:", err)
+		}
+		challenge := []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "challenge"}}
+		start := time.Now()
+		if _, err := p.AppendRecords(ctx, testZone, challenge); !errors.Is(err, ErrZoneNotFound) || errors.Is(err, ErrConflict) {
+			t.Fatal("expected a zone not found error back, received", err)
+		}
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Fatal("expected the missing managed zone not to be retried, took", elapsed)
+		}
+		if p.getCachedZones().zones != nil {
+			t.Fatal("expected the cached managed zones to be forgotten")
+		}
+		if _, err := p.GetRecords(ctx, testZone); !errors.Is(err, ErrZoneNotFound) {
+			t.Fatal("expected a zone not found error back, received", err)
+		}
+		p.ZoneNames = map[string]string{testZone: "libdns"}
+		if _, err := p.DeleteRecords(ctx, testZone, challenge); !errors.Is(err, ErrZoneNotFound) {
+			t.Fatal("expected the records of a missing managed zone not to be read as missing, received", err)
+		}
+	})
+	t.Run("denied requests are reported", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"code":403,"message":"Forbidden","errors":[{"reason":"forbidden"}]}}`))
+		}))
+		defer server.Close()
+		p := Provider{
+			Project:    testProject,
+			Endpoint:   server.URL + "/",
+			HTTPClient: server.Client(),
+			ZoneNames:  map[string]string{testZone: "libdns"},
+		}
+		_, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{
+			libdns.TXT{Name: "_acme-challenge", Text: "challenge"},
+		})
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Fatal("expected a permission denied error back, received", err)
+		}
+	})
+}
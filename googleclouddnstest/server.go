@@ -0,0 +1,696 @@
+// Package googleclouddnstest provides an in-memory fake of the Google Cloud DNS API for testing code
+// built on the googleclouddns provider without network access or GCP credentials.
+//
+// The fake implements the managedZones, resourceRecordSets and changes resources of the Cloud DNS v1
+// API, including the 404, 409 and 412 errors returned by Google. Other errors, e.g. rate limits,
+// can be injected with InjectFault:
+//
+//	server := googleclouddnstest.NewServer()
+//	defer server.Close()
+//	server.AddZone("my-project", &dns.ManagedZone{Name: "example-com", DnsName: "example.com."})
+//	provider := googleclouddns.Provider{
+//		Project:    "my-project",
+//		Endpoint:   server.Endpoint(),
+//		HTTPClient: server.Client(),
+//	}
+package googleclouddnstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/dns/v1"
+)
+
+// Server is an in-memory Google Cloud DNS API server. Use Endpoint and Client to point a
+// googleclouddns.Provider at it.
+type Server struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	projects map[string]map[string]*zone
+	faults   []*Fault
+	latency  time.Duration
+	pending  int
+	nextID   uint64
+}
+
+// Fault makes the server fail requests with an error instead of serving them.
+type Fault struct {
+	// Method is the HTTP method of the requests to fail, e.g. "POST". Any method matches if empty.
+	Method string
+	// Resource is the resource of the requests to fail: "managedZones", "rrsets" or "changes". Any
+	// resource matches if empty.
+	Resource string
+	// Code is the HTTP status code returned, e.g. 429 or 503.
+	Code int
+	// RetryAfter is returned in the Retry-After header if set.
+	RetryAfter time.Duration
+	// Count is the number of requests to fail. The fault never expires if it is 0.
+	Count int
+	// Applied serves the requests before failing them, as when Cloud DNS applies a change but its
+	// response is lost.
+	Applied bool
+}
+
+type zone struct {
+	managedZone *dns.ManagedZone
+	rrsets      map[rrsetKey]*dns.ResourceRecordSet
+	changes     []*dns.Change
+	pending     map[string]int
+}
+
+type rrsetKey struct {
+	name       string
+	recordType string
+}
+
+// NewServer starts and returns a new fake Cloud DNS server. The caller should call Close when
+// finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		projects: make(map[string]map[string]*zone),
+		nextID:   1000,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Endpoint returns the base URL of the server to use as googleclouddns.Provider.Endpoint.
+func (s *Server) Endpoint() string {
+	return s.URL + "/"
+}
+
+// AddZone creates the managed zone in the project along with its NS and SOA record sets, the
+// same as Cloud DNS does. The zone is public unless its visibility is set. It returns a copy of the
+// zone as stored by the server.
+func (s *Server) AddZone(project string, managedZone *dns.ManagedZone) *dns.ManagedZone {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	z := s.addZone(project, clone(managedZone))
+	return clone(z.managedZone)
+}
+
+// InjectFault makes the server fail the requests matching the fault until it expires. Faults are
+// matched in the order they were injected.
+func (s *Server) InjectFault(fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// SetLatency delays every response of the server, e.g. to measure the effect of concurrent requests
+// against the latency of the real Cloud DNS API. Requests are still served concurrently, and the
+// delay ends early when the client cancels the request.
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.latency = latency
+}
+
+// SetPendingPolls makes the changes created from now on report a "pending" status for the specified
+// number of reads before they are "done", the same as Cloud DNS does while a change is propagated to
+// its authoritative servers. The records are updated right away.
+func (s *Server) SetPendingPolls(polls int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending = polls
+}
+
+// AddRecordSet stores the record set in the managed zone of the project, replacing any record set
+// with the same name and type.
+func (s *Server) AddRecordSet(project, managedZone string, rrs *dns.ResourceRecordSet) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	z, ok := s.projects[project][managedZone]
+	if !ok {
+		return fmt.Errorf("managed zone %s does not exist in project %s", managedZone, project)
+	}
+	rrs = clone(rrs)
+	if apiErr := z.validate(rrs, "rrset"); apiErr != nil {
+		return apiErr
+	}
+	z.rrsets[keyOf(rrs)] = rrs
+	return nil
+}
+
+// RecordSet returns a copy of the record set with the specified name and type, or nil if it does
+// not exist.
+func (s *Server) RecordSet(project, managedZone, name, recordType string) *dns.ResourceRecordSet {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	z, ok := s.projects[project][managedZone]
+	if !ok {
+		return nil
+	}
+	rrs, ok := z.rrsets[rrsetKey{name: name, recordType: recordType}]
+	if !ok {
+		return nil
+	}
+	return clone(rrs)
+}
+
+// Changes returns a copy of all the changes made to the managed zone, oldest first.
+func (s *Server) Changes(project, managedZone string) []*dns.Change {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	z, ok := s.projects[project][managedZone]
+	if !ok {
+		return nil
+	}
+	changes := make([]*dns.Change, 0, len(z.changes))
+	for _, change := range z.changes {
+		changes = append(changes, clone(change))
+	}
+	return changes
+}
+
+func (s *Server) addZone(project string, managedZone *dns.ManagedZone) *zone {
+	if s.projects[project] == nil {
+		s.projects[project] = make(map[string]*zone)
+	}
+	s.nextID++
+	managedZone.Id = s.nextID
+	managedZone.Kind = "dns#managedZone"
+	managedZone.CreationTime = time.Now().UTC().Format(time.RFC3339Nano)
+	if managedZone.Visibility == "" {
+		managedZone.Visibility = "public"
+	}
+	if len(managedZone.NameServers) == 0 {
+		for i := 1; i <= 4; i++ {
+			managedZone.NameServers = append(managedZone.NameServers, fmt.Sprintf("ns-cloud-a%d.googledomains.com.", i))
+		}
+	}
+	z := &zone{
+		managedZone: managedZone,
+		rrsets:      make(map[rrsetKey]*dns.ResourceRecordSet),
+	}
+	z.rrsets[rrsetKey{name: managedZone.DnsName, recordType: "NS"}] = &dns.ResourceRecordSet{
+		Kind:    "dns#resourceRecordSet",
+		Name:    managedZone.DnsName,
+		Rrdatas: append([]string(nil), managedZone.NameServers...),
+		Ttl:     21600,
+		Type:    "NS",
+	}
+	z.rrsets[rrsetKey{name: managedZone.DnsName, recordType: "SOA"}] = &dns.ResourceRecordSet{
+		Kind:    "dns#resourceRecordSet",
+		Name:    managedZone.DnsName,
+		Rrdatas: []string{managedZone.NameServers[0] + " cloud-dns-hostmaster.google.com. 1 21600 3600 259200 300"},
+		Ttl:     21600,
+		Type:    "SOA",
+	}
+	s.projects[project][managedZone.Name] = z
+	return z
+}
+
+// serveHTTP routes the Cloud DNS v1 API requests, e.g.
+// /dns/v1/projects/{project}/managedZones/{managedZone}/rrsets/{name}/{type}.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	latency := s.latency
+	s.mutex.Unlock()
+	timer := time.NewTimer(latency)
+	select {
+	case <-r.Context().Done(): // the client gave up on the request
+		timer.Stop()
+		return
+	case <-timer.C:
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path, ok := strings.CutPrefix(r.URL.Path, "/dns/v1/projects/")
+	if !ok {
+		writeError(w, newError(http.StatusNotFound, "notFound", "unknown path %s", r.URL.Path))
+		return
+	}
+	segments := strings.Split(path, "/")
+	if len(segments) < 2 || segments[1] != "managedZones" {
+		writeError(w, newError(http.StatusNotFound, "notFound", "unknown path %s", r.URL.Path))
+		return
+	}
+	if fault := s.matchFault(r, segments); fault != nil {
+		if !fault.Applied {
+			writeFault(w, r, fault)
+			return
+		}
+		defer writeFault(w, r, fault)
+		w = httptest.NewRecorder() // the response of the served request is lost
+	}
+	project := segments[0]
+	if len(segments) == 2 {
+		s.serveZones(w, r, project)
+		return
+	}
+	z, ok := s.projects[project][segments[2]]
+	if !ok {
+		writeError(w, newError(http.StatusNotFound, "notFound",
+			"The 'parameters.managedZone' resource named '%s' does not exist.", segments[2]))
+		return
+	}
+	switch {
+	case len(segments) == 3:
+		s.serveZone(w, r, project, z)
+	case segments[3] == "rrsets":
+		z.serveRecordSets(w, r, segments[4:])
+	case segments[3] == "changes":
+		s.serveChanges(w, r, z, segments[4:])
+	default:
+		writeError(w, newError(http.StatusNotFound, "notFound", "unknown path %s", r.URL.Path))
+	}
+}
+
+// matchFault returns the first fault matching the request, if any, and expires it once it has failed
+// the requested number of requests.
+func (s *Server) matchFault(r *http.Request, segments []string) *Fault {
+	resource := "managedZones"
+	if len(segments) > 3 {
+		resource = segments[3]
+	}
+	for i, fault := range s.faults {
+		if (fault.Method != "" && fault.Method != r.Method) || (fault.Resource != "" && fault.Resource != resource) {
+			continue
+		}
+		if fault.Count > 0 {
+			if fault.Count--; fault.Count == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+// writeFault writes the error of the fault.
+func writeFault(w http.ResponseWriter, r *http.Request, fault *Fault) {
+	if fault.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(fault.RetryAfter.Seconds())))
+	}
+	writeError(w, newError(fault.Code, "injectedFault", "injected fault for %s %s", r.Method, r.URL.Path))
+}
+
+func (s *Server) serveZones(w http.ResponseWriter, r *http.Request, project string) {
+	switch r.Method {
+	case http.MethodGet:
+		zones := make([]*dns.ManagedZone, 0)
+		dnsName := r.URL.Query().Get("dnsName")
+		for _, z := range s.projects[project] {
+			if dnsName == "" || z.managedZone.DnsName == dnsName {
+				zones = append(zones, z.managedZone)
+			}
+		}
+		sort.Slice(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+		zones, nextPageToken, apiErr := page(r, zones)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		writeJSON(w, &dns.ManagedZonesListResponse{
+			Kind:          "dns#managedZonesListResponse",
+			ManagedZones:  zones,
+			NextPageToken: nextPageToken,
+		})
+	case http.MethodPost:
+		managedZone := &dns.ManagedZone{}
+		if apiErr := readJSON(r, managedZone); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		if managedZone.Name == "" || !strings.HasSuffix(managedZone.DnsName, ".") {
+			writeError(w, newError(http.StatusBadRequest, "invalid", "Invalid value for 'entity.managedZone'"))
+			return
+		}
+		if _, ok := s.projects[project][managedZone.Name]; ok {
+			writeError(w, newError(http.StatusConflict, "alreadyExists",
+				"The resource 'entity.managedZone' named '%s' already exists", managedZone.Name))
+			return
+		}
+		writeJSON(w, s.addZone(project, managedZone).managedZone)
+	default:
+		writeError(w, newError(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method))
+	}
+}
+
+func (s *Server) serveZone(w http.ResponseWriter, r *http.Request, project string, z *zone) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, z.managedZone)
+	case http.MethodDelete:
+		for key := range z.rrsets {
+			if key.name != z.managedZone.DnsName || (key.recordType != "NS" && key.recordType != "SOA") {
+				writeError(w, newError(http.StatusBadRequest, "containerNotEmpty",
+					"The resource named '%s' cannot be deleted because it is not empty", z.managedZone.Name))
+				return
+			}
+		}
+		delete(s.projects[project], z.managedZone.Name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, newError(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method))
+	}
+}
+
+func (z *zone) serveRecordSets(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			rrsets := make([]*dns.ResourceRecordSet, 0)
+			for _, rrs := range z.sortedRecordSets() {
+				if (query.Get("name") == "" || rrs.Name == query.Get("name")) &&
+					(query.Get("type") == "" || rrs.Type == query.Get("type")) {
+					rrsets = append(rrsets, rrs)
+				}
+			}
+			rrsets, nextPageToken, apiErr := page(r, rrsets)
+			if apiErr != nil {
+				writeError(w, apiErr)
+				return
+			}
+			writeJSON(w, &dns.ResourceRecordSetsListResponse{
+				Kind:          "dns#resourceRecordSetsListResponse",
+				Rrsets:        rrsets,
+				NextPageToken: nextPageToken,
+			})
+		case http.MethodPost:
+			rrs := &dns.ResourceRecordSet{}
+			if apiErr := readJSON(r, rrs); apiErr != nil {
+				writeError(w, apiErr)
+				return
+			}
+			if apiErr := z.validate(rrs, "entity.rrset"); apiErr != nil {
+				writeError(w, apiErr)
+				return
+			}
+			if _, ok := z.rrsets[keyOf(rrs)]; ok {
+				writeError(w, newError(http.StatusConflict, "alreadyExists",
+					"The resource 'entity.rrset' named '%s (%s)' already exists", rrs.Name, rrs.Type))
+				return
+			}
+			z.rrsets[keyOf(rrs)] = rrs
+			writeJSON(w, rrs)
+		default:
+			writeError(w, newError(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method))
+		}
+		return
+	}
+	if len(segments) != 2 {
+		writeError(w, newError(http.StatusNotFound, "notFound", "unknown path %s", r.URL.Path))
+		return
+	}
+	key := rrsetKey{name: segments[0], recordType: segments[1]}
+	existing, ok := z.rrsets[key]
+	if !ok {
+		writeError(w, newError(http.StatusNotFound, "notFound",
+			"The 'parameters.name' resource named '%s' does not exist.", key.name))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, existing)
+	case http.MethodPatch:
+		rrs := &dns.ResourceRecordSet{}
+		if apiErr := readJSON(r, rrs); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		rrs.Name, rrs.Type = key.name, key.recordType
+		if apiErr := z.validate(rrs, "entity.rrset"); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		z.rrsets[key] = rrs
+		writeJSON(w, rrs)
+	case http.MethodDelete:
+		delete(z.rrsets, key)
+		writeJSON(w, &dns.ResourceRecordSetsDeleteResponse{})
+	default:
+		writeError(w, newError(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method))
+	}
+}
+
+func (s *Server) serveChanges(w http.ResponseWriter, r *http.Request, z *zone, segments []string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		changes := append([]*dns.Change(nil), z.changes...)
+		if r.URL.Query().Get("sortOrder") == "descending" {
+			slices.Reverse(changes)
+		}
+		changes, nextPageToken, apiErr := page(r, changes)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		writeJSON(w, &dns.ChangesListResponse{
+			Kind:          "dns#changesListResponse",
+			Changes:       changes,
+			NextPageToken: nextPageToken,
+		})
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		change := &dns.Change{}
+		if apiErr := readJSON(r, change); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		if apiErr := z.apply(change); apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		s.nextID++
+		change.Id = strconv.FormatUint(s.nextID, 10)
+		change.Kind = "dns#change"
+		change.StartTime = time.Now().UTC().Format(time.RFC3339Nano)
+		change.Status = "done"
+		change.IsServing = true
+		if s.pending > 0 {
+			if z.pending == nil {
+				z.pending = make(map[string]int)
+			}
+			z.pending[change.Id] = s.pending
+			change.Status = "pending"
+			change.IsServing = false
+		}
+		z.changes = append(z.changes, change)
+		writeJSON(w, change)
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		for _, change := range z.changes {
+			if change.Id == segments[0] {
+				writeJSON(w, change)
+				if _, ok := z.pending[change.Id]; !ok {
+					return
+				}
+				if z.pending[change.Id]--; z.pending[change.Id] == 0 {
+					delete(z.pending, change.Id)
+					change.Status = "done"
+					change.IsServing = true
+				}
+				return
+			}
+		}
+		writeError(w, newError(http.StatusNotFound, "notFound",
+			"The 'parameters.changeId' resource named '%s' does not exist.", segments[0]))
+	default:
+		writeError(w, newError(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method))
+	}
+}
+
+// apply makes all the deletions and additions of the change, or none of them if any fails.
+func (z *zone) apply(change *dns.Change) *apiError {
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		return newError(http.StatusBadRequest, "required", "The change must contain at least one addition or deletion")
+	}
+	rrsets := make(map[rrsetKey]*dns.ResourceRecordSet, len(z.rrsets))
+	for key, rrs := range z.rrsets {
+		rrsets[key] = rrs
+	}
+	for i, deletion := range change.Deletions {
+		field := fmt.Sprintf("entity.change.deletions[%d]", i)
+		if apiErr := z.validate(deletion, field); apiErr != nil {
+			return apiErr
+		}
+		existing, ok := rrsets[keyOf(deletion)]
+		if !ok {
+			return newError(http.StatusNotFound, "notFound",
+				"The '%s' resource named '%s (%s)' does not exist.", field, deletion.Name, deletion.Type)
+		}
+		if !equalRecordSets(existing, deletion) {
+			return newError(http.StatusPreconditionFailed, "conditionNotMet",
+				"Precondition not met for '%s'", field)
+		}
+		delete(rrsets, keyOf(deletion))
+	}
+	for i, addition := range change.Additions {
+		field := fmt.Sprintf("entity.change.additions[%d]", i)
+		if apiErr := z.validate(addition, field); apiErr != nil {
+			return apiErr
+		}
+		if _, ok := rrsets[keyOf(addition)]; ok {
+			return newError(http.StatusConflict, "alreadyExists",
+				"The resource '%s' named '%s (%s)' already exists", field, addition.Name, addition.Type)
+		}
+		rrsets[keyOf(addition)] = addition
+	}
+	z.rrsets = rrsets
+	return nil
+}
+
+// validate checks the record set belongs to the zone and normalizes it the same way Cloud DNS does.
+func (z *zone) validate(rrs *dns.ResourceRecordSet, field string) *apiError {
+	if rrs.Type == "" {
+		return newError(http.StatusBadRequest, "required", "Required field '%s.type' not specified", field)
+	}
+	if rrs.Name != z.managedZone.DnsName && !strings.HasSuffix(rrs.Name, "."+z.managedZone.DnsName) {
+		return newError(http.StatusBadRequest, "invalid",
+			"Invalid value for '%s.name': '%s'", field, rrs.Name)
+	}
+	if len(rrs.Rrdatas) == 0 && rrs.RoutingPolicy == nil {
+		return newError(http.StatusBadRequest, "required", "Required field '%s.rrdatas' not specified", field)
+	}
+	rrs.Kind = "dns#resourceRecordSet"
+	if rrs.Type == "TXT" {
+		for i, value := range rrs.Rrdatas {
+			rrs.Rrdatas[i] = quoteTXT(value)
+		}
+	}
+	return nil
+}
+
+func (z *zone) sortedRecordSets() []*dns.ResourceRecordSet {
+	rrsets := make([]*dns.ResourceRecordSet, 0, len(z.rrsets))
+	for _, rrs := range z.rrsets {
+		rrsets = append(rrsets, rrs)
+	}
+	sort.Slice(rrsets, func(i, j int) bool {
+		if rrsets[i].Name != rrsets[j].Name {
+			return rrsets[i].Name < rrsets[j].Name
+		}
+		return rrsets[i].Type < rrsets[j].Type
+	})
+	return rrsets
+}
+
+// quoteTXT quotes TXT data the way Cloud DNS stores it. Unquoted data is split on whitespace into
+// separate character strings.
+func quoteTXT(value string) string {
+	if strings.HasPrefix(value, `"`) {
+		return value
+	}
+	fields := strings.Fields(value)
+	for i, field := range fields {
+		fields[i] = `"` + field + `"`
+	}
+	return strings.Join(fields, " ")
+}
+
+func keyOf(rrs *dns.ResourceRecordSet) rrsetKey {
+	return rrsetKey{name: rrs.Name, recordType: rrs.Type}
+}
+
+// equalRecordSets compares the record sets the way Cloud DNS does for deletions: the values can be in
+// any order but everything else has to match.
+func equalRecordSets(a, b *dns.ResourceRecordSet) bool {
+	if a.Name != b.Name || a.Type != b.Type || a.Ttl != b.Ttl || len(a.Rrdatas) != len(b.Rrdatas) {
+		return false
+	}
+	aValues := append([]string(nil), a.Rrdatas...)
+	bValues := append([]string(nil), b.Rrdatas...)
+	sort.Strings(aValues)
+	sort.Strings(bValues)
+	for i := range aValues {
+		if aValues[i] != bValues[i] {
+			return false
+		}
+	}
+	aPolicy, _ := json.Marshal(a.RoutingPolicy)
+	bPolicy, _ := json.Marshal(b.RoutingPolicy)
+	return string(aPolicy) == string(bPolicy)
+}
+
+// page returns the page of items requested with the maxResults and pageToken parameters along with
+// the token of the next page, if any.
+func page[T any](r *http.Request, items []T) ([]T, string, *apiError) {
+	query := r.URL.Query()
+	start := 0
+	if token := query.Get("pageToken"); token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil || start < 0 || start > len(items) {
+			return nil, "", newError(http.StatusBadRequest, "invalid", "Invalid value for 'parameters.pageToken': '%s'", token)
+		}
+	}
+	end := len(items)
+	if maxResults := query.Get("maxResults"); maxResults != "" {
+		size, err := strconv.Atoi(maxResults)
+		if err != nil || size <= 0 {
+			return nil, "", newError(http.StatusBadRequest, "invalid", "Invalid value for 'parameters.maxResults': '%s'", maxResults)
+		}
+		end = min(start+size, len(items))
+	}
+	nextPageToken := ""
+	if end < len(items) {
+		nextPageToken = strconv.Itoa(end)
+	}
+	return items[start:end], nextPageToken, nil
+}
+
+func readJSON(r *http.Request, v any) *apiError {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return newError(http.StatusBadRequest, "parseError", "Parse Error: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, apiErr *apiError) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(apiErr.Code)
+	json.NewEncoder(w).Encode(map[string]*apiError{"error": apiErr})
+}
+
+// apiError is the error body returned by Google APIs.
+type apiError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Errors  []apiErrorEntry `json:"errors"`
+}
+
+type apiErrorEntry struct {
+	Message string `json:"message"`
+	Domain  string `json:"domain"`
+	Reason  string `json:"reason"`
+}
+
+func newError(code int, reason, format string, args ...any) *apiError {
+	message := fmt.Sprintf(format, args...)
+	return &apiError{
+		Code:    code,
+		Message: message,
+		Errors:  []apiErrorEntry{{Message: message, Domain: "global", Reason: reason}},
+	}
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// clone returns a deep copy of a Cloud DNS API object.
+func clone[T any](v *T) *T {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	c := new(T)
+	if err := json.Unmarshal(data, c); err != nil {
+		panic(err)
+	}
+	return c
+}
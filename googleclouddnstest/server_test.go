@@ -0,0 +1,211 @@
+package googleclouddnstest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/libdns/googleclouddns"
+	"github.com/libdns/googleclouddns/googleclouddnstest"
+	"github.com/libdns/libdns"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+var (
+	testProject = `test-dev`
+	testZone    = `libdns.io.`
+)
+
+func newTestServer(t *testing.T) (*googleclouddnstest.Server, *dns.Service) {
+	server := googleclouddnstest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddZone(testProject, &dns.ManagedZone{Name: "libdns", DnsName: testZone})
+	service, err := dns.NewService(context.Background(),
+		option.WithEndpoint(server.Endpoint()), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, service
+}
+
+func expectCode(t *testing.T, err error, code int) {
+	t.Helper()
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) || gErr.Code != code {
+		t.Fatalf("expected a %d error back, received %v", code, err)
+	}
+}
+
+func Test_Provider(t *testing.T) {
+	server, _ := newTestServer(t)
+	p := googleclouddns.Provider{
+		Project:    testProject,
+		Endpoint:   server.Endpoint(),
+		HTTPClient: server.Client(),
+	}
+	ctx := context.Background()
+	t.Run("new zones hold the NS and SOA records", func(t *testing.T) {
+		records, err := p.GetRecords(ctx, testZone)
+		if err != nil {
+			t.Fatal("error listing records from the test zone:", err)
+		}
+		if len(records) != 5 {
+			t.Fatal("expected five records back, received", len(records))
+		}
+	})
+	t.Run("appended records are stored", func(t *testing.T) {
+		records, err := p.AppendRecords(ctx, testZone, []libdns.Record{
+			libdns.TXT{Name: "_acme-challenge", Text: "first challenge", TTL: time.Minute},
+			libdns.TXT{Name: "_acme-challenge", Text: "1234567890abcdef", TTL: time.Minute},
+		})
+		if err != nil {
+			t.Fatal("error appending records to the test zone:", err)
+		}
+		if len(records) != 2 {
+			t.Fatal("expected two records back, received", len(records))
+		}
+		rrs := server.RecordSet(testProject, "libdns", "_acme-challenge.libdns.io.", "TXT")
+		if rrs == nil || len(rrs.Rrdatas) != 2 || rrs.Rrdatas[0] != `"first challenge"` || rrs.Ttl != 60 {
+			t.Fatalf("unexpected record set stored: %+v", rrs)
+		}
+	})
+	t.Run("deleted records are removed", func(t *testing.T) {
+		records, err := p.DeleteRecords(ctx, testZone, []libdns.Record{
+			libdns.TXT{Name: "_acme-challenge", Text: "first challenge"},
+		})
+		if err != nil {
+			t.Fatal("error deleting records from the test zone:", err)
+		}
+		if len(records) != 1 {
+			t.Fatal("expected one record back, received", len(records))
+		}
+		rrs := server.RecordSet(testProject, "libdns", "_acme-challenge.libdns.io.", "TXT")
+		if rrs == nil || len(rrs.Rrdatas) != 1 || rrs.Rrdatas[0] != `"1234567890abcdef"` {
+			t.Fatalf("unexpected record set stored: %+v", rrs)
+		}
+		if changes := server.Changes(testProject, "libdns"); len(changes) != 2 {
+			t.Fatal("expected two changes to the zone, found", len(changes))
+		}
+	})
+}
+
+func Test_Errors(t *testing.T) {
+	server, service := newTestServer(t)
+	ctx := context.Background()
+	rrs := &dns.ResourceRecordSet{Name: "www.libdns.io.", Type: "A", Ttl: 300, Rrdatas: []string{"127.0.0.1"}}
+	if err := server.AddRecordSet(testProject, "libdns", rrs); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("unknown managed zones are not found", func(t *testing.T) {
+		_, err := service.ResourceRecordSets.List(testProject, "i-do-not-exist").Context(ctx).Do()
+		expectCode(t, err, http.StatusNotFound)
+	})
+	t.Run("unknown record sets are not found", func(t *testing.T) {
+		_, err := service.ResourceRecordSets.Get(testProject, "libdns", "ftp.libdns.io.", "A").Context(ctx).Do()
+		expectCode(t, err, http.StatusNotFound)
+	})
+	t.Run("existing record sets cannot be created again", func(t *testing.T) {
+		_, err := service.ResourceRecordSets.Create(testProject, "libdns", rrs).Context(ctx).Do()
+		expectCode(t, err, http.StatusConflict)
+		_, err = service.Changes.Create(testProject, "libdns", &dns.Change{
+			Additions: []*dns.ResourceRecordSet{rrs},
+		}).Context(ctx).Do()
+		expectCode(t, err, http.StatusConflict)
+	})
+	t.Run("deletions must match the existing record set", func(t *testing.T) {
+		stale := &dns.ResourceRecordSet{Name: "www.libdns.io.", Type: "A", Ttl: 60, Rrdatas: []string{"127.0.0.1"}}
+		_, err := service.Changes.Create(testProject, "libdns", &dns.Change{
+			Deletions: []*dns.ResourceRecordSet{stale},
+		}).Context(ctx).Do()
+		expectCode(t, err, http.StatusPreconditionFailed)
+	})
+	t.Run("failed changes are not applied", func(t *testing.T) {
+		_, err := service.Changes.Create(testProject, "libdns", &dns.Change{
+			Additions: []*dns.ResourceRecordSet{
+				{Name: "ftp.libdns.io.", Type: "A", Ttl: 300, Rrdatas: []string{"127.0.0.2"}},
+				rrs,
+			},
+		}).Context(ctx).Do()
+		expectCode(t, err, http.StatusConflict)
+		if server.RecordSet(testProject, "libdns", "ftp.libdns.io.", "A") != nil {
+			t.Fatal("the change was partially applied")
+		}
+	})
+	t.Run("record sets must be in the zone", func(t *testing.T) {
+		_, err := service.Changes.Create(testProject, "libdns", &dns.Change{
+			Additions: []*dns.ResourceRecordSet{{Name: "www.example.com.", Type: "A", Rrdatas: []string{"127.0.0.1"}}},
+		}).Context(ctx).Do()
+		expectCode(t, err, http.StatusBadRequest)
+	})
+}
+
+func Test_Paging(t *testing.T) {
+	server, service := newTestServer(t)
+	server.AddZone(testProject, &dns.ManagedZone{Name: "example", DnsName: "example.com."})
+	server.AddZone(testProject, &dns.ManagedZone{Name: "internal", DnsName: "internal.example.com.", Visibility: "private"})
+	zones := make([]string, 0)
+	err := service.ManagedZones.List(testProject).MaxResults(1).Pages(context.Background(), func(page *dns.ManagedZonesListResponse) error {
+		if len(page.ManagedZones) != 1 {
+			t.Fatal("expected one zone per page, received", len(page.ManagedZones))
+		}
+		zones = append(zones, page.ManagedZones[0].Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("error listing managed zones:", err)
+	}
+	if len(zones) != 3 {
+		t.Fatal("expected three zones back, received", len(zones))
+	}
+}
+
+func Test_Faults(t *testing.T) {
+	server, service := newTestServer(t)
+	server.InjectFault(googleclouddnstest.Fault{Method: http.MethodGet, Resource: "rrsets", Code: http.StatusTooManyRequests, Count: 1})
+	ctx := context.Background()
+	_, err := service.ManagedZones.Get(testProject, "libdns").Context(ctx).Do()
+	if err != nil {
+		t.Fatal("error getting the managed zone:", err)
+	}
+	_, err = service.ResourceRecordSets.List(testProject, "libdns").Context(ctx).Do()
+	expectCode(t, err, http.StatusTooManyRequests)
+	_, err = service.ResourceRecordSets.List(testProject, "libdns").Context(ctx).Do()
+	if err != nil {
+		t.Fatal("expected the fault to expire, received", err)
+	}
+	server.InjectFault(googleclouddnstest.Fault{Method: http.MethodPost, Resource: "changes", Code: http.StatusServiceUnavailable, Count: 1, Applied: true})
+	_, err = service.Changes.Create(testProject, "libdns", &dns.Change{
+		Additions: []*dns.ResourceRecordSet{{Name: "www.libdns.io.", Type: "A", Ttl: 300, Rrdatas: []string{"127.0.0.1"}}},
+	}).Context(ctx).Do()
+	expectCode(t, err, http.StatusServiceUnavailable)
+	if server.RecordSet(testProject, "libdns", "www.libdns.io.", "A") == nil {
+		t.Fatal("expected the failed change to be applied")
+	}
+}
+
+func Test_PendingChanges(t *testing.T) {
+	server, service := newTestServer(t)
+	server.SetPendingPolls(2)
+	ctx := context.Background()
+	change, err := service.Changes.Create(testProject, "libdns", &dns.Change{
+		Additions: []*dns.ResourceRecordSet{{Name: "www.libdns.io.", Type: "A", Ttl: 300, Rrdatas: []string{"127.0.0.1"}}},
+	}).Context(ctx).Do()
+	if err != nil {
+		t.Fatal("error creating the change:", err)
+	}
+	if server.RecordSet(testProject, "libdns", "www.libdns.io.", "A") == nil {
+		t.Fatal("expected the record set to be stored right away")
+	}
+	for _, expected := range []string{"pending", "pending", "pending", "done"} {
+		if change.Status != expected {
+			t.Fatalf("expected the change to be %s, found %s", expected, change.Status)
+		}
+		if change, err = service.Changes.Get(testProject, "libdns", change.Id).Context(ctx).Do(); err != nil {
+			t.Fatal("error getting the change:", err)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package googleclouddns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/api/dns/v1"
+)
+
+const (
+	// nameServerTimeout limits the time spent on a single DNS query
+	nameServerTimeout = time.Second * 5
+)
+
+// nameServerPort is the port the name servers of the managed zones are queried on.
+var nameServerPort = "53"
+
+// recordTypes maps the record types to their DNS codes.
+var recordTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"NS":    dnsmessage.TypeNS,
+	"CNAME": dnsmessage.TypeCNAME,
+	"SOA":   dnsmessage.TypeSOA,
+	"PTR":   dnsmessage.TypePTR,
+	"MX":    dnsmessage.TypeMX,
+	"TXT":   dnsmessage.TypeTXT,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"SRV":   dnsmessage.TypeSRV,
+	"NAPTR": 35,
+	"DS":    43,
+	"SSHFP": 44,
+	"TLSA":  52,
+	"SVCB":  64,
+	"HTTPS": 65,
+	"CAA":   257,
+}
+
+// WaitForRecord waits until the record is served by every authoritative name server of the zone. The
+// name servers are read from the managed zone and queried directly over DNS, or ResolverAddress is
+// queried instead if it is set. The data of A, AAAA, CNAME, MX, NS, PTR, SRV and TXT records is
+// compared, other records are served as soon as a record of the same name and type is. It polls the
+// name servers until ctx is done or the PropagationTimeout is reached.
+func (p *Provider) WaitForRecord(ctx context.Context, zone string, record libdns.Record) error {
+	rr := record.RR()
+	recordType, err := dnsType(rr.Type)
+	if err != nil {
+		return err
+	}
+	if err := p.newService(ctx); err != nil {
+		return err
+	}
+	timeout := p.PropagationTimeout
+	if timeout <= 0 {
+		timeout = defaultPropagationTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addresses, err := p.getNameServerAddresses(ctx, zone)
+	if err != nil {
+		return err
+	}
+	name := libdns.AbsoluteName(rr.Name, zone)
+	for {
+		pendingAddresses := make([]string, 0)
+		for _, address := range addresses {
+			served, err := isServedBy(ctx, address, name, recordType, rr)
+			if err != nil {
+				p.logger().Debug("unable to query name server", "address", address, "name", name, "type", rr.Type, "error", err)
+			}
+			if !served {
+				pendingAddresses = append(pendingAddresses, address)
+			}
+		}
+		if len(pendingAddresses) == 0 {
+			return nil
+		}
+		addresses = pendingAddresses
+		timer := time.NewTimer(changePollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("record %s (%s) is not served by %s yet: %w", name, rr.Type, strings.Join(addresses, ", "), ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// getNameServerAddresses returns the addresses of the authoritative name servers of the zone, or the
+// ResolverAddress if it is set.
+func (p *Provider) getNameServerAddresses(ctx context.Context, zone string) ([]string, error) {
+	if p.ResolverAddress != "" {
+		return []string{p.ResolverAddress}, nil
+	}
+	gcdZone, err := p.getCloudDNSZone(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	managedZone, err := retry(ctx, p, isTransientError, func() (*dns.ManagedZone, error) {
+		return p.service.ManagedZones.Get(p.Project, gcdZone).Context(ctx).Do()
+	})
+	if err != nil {
+		return nil, wrapGoogleError(err, ErrZoneNotFound)
+	}
+	if len(managedZone.NameServers) == 0 {
+		return nil, fmt.Errorf("managed zone %s has no name servers", gcdZone)
+	}
+	addresses := make([]string, 0, len(managedZone.NameServers))
+	for _, nameServer := range managedZone.NameServers {
+		addresses = append(addresses, net.JoinHostPort(strings.TrimSuffix(nameServer, "."), nameServerPort))
+	}
+	return addresses, nil
+}
+
+// dnsType returns the DNS code of the record type, e.g. "TXT" or "TYPE16".
+func dnsType(recordType string) (dnsmessage.Type, error) {
+	if t, ok := recordTypes[strings.ToUpper(recordType)]; ok {
+		return t, nil
+	}
+	if code, ok := strings.CutPrefix(strings.ToUpper(recordType), "TYPE"); ok {
+		if t, err := strconv.ParseUint(code, 10, 16); err == nil {
+			return dnsmessage.Type(t), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported record type %s", recordType)
+}
+
+// isServedBy queries the name server at the address and returns true if it answers with the record.
+func isServedBy(ctx context.Context, address, name string, recordType dnsmessage.Type, rr libdns.RR) (bool, error) {
+	response, err := queryNameServer(ctx, address, name, recordType)
+	if err != nil {
+		return false, err
+	}
+	for _, answer := range response.Answers {
+		if answer.Header.Type == recordType && strings.EqualFold(answer.Header.Name.String(), name) && answerMatches(answer, rr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// answerMatches returns true if the answer holds the data of the record. Records without data, or
+// of types whose data is not compared, match any answer.
+func answerMatches(answer dnsmessage.Resource, rr libdns.RR) bool {
+	if rr.Data == "" {
+		return true
+	}
+	switch body := answer.Body.(type) {
+	case *dnsmessage.AResource:
+		addr, err := netip.ParseAddr(rr.Data)
+		return err == nil && addr == netip.AddrFrom4(body.A)
+	case *dnsmessage.AAAAResource:
+		addr, err := netip.ParseAddr(rr.Data)
+		return err == nil && addr == netip.AddrFrom16(body.AAAA)
+	case *dnsmessage.TXTResource:
+		return strings.Join(body.TXT, "") == rr.Data
+	case *dnsmessage.CNAMEResource:
+		return sameData(body.CNAME.String(), rr.Data)
+	case *dnsmessage.NSResource:
+		return sameData(body.NS.String(), rr.Data)
+	case *dnsmessage.PTRResource:
+		return sameData(body.PTR.String(), rr.Data)
+	case *dnsmessage.MXResource:
+		return sameData(fmt.Sprintf("%d %s", body.Pref, body.MX), rr.Data)
+	case *dnsmessage.SRVResource:
+		return sameData(fmt.Sprintf("%d %d %d %s", body.Priority, body.Weight, body.Port, body.Target), rr.Data)
+	}
+	return true
+}
+
+// sameData compares record data ignoring case, extra spaces and the trailing dots of names.
+func sameData(a, b string) bool {
+	normalize := func(data string) string {
+		fields := strings.Fields(strings.ToLower(data))
+		for i, field := range fields {
+			fields[i] = strings.TrimSuffix(field, ".")
+		}
+		return strings.Join(fields, " ")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// queryNameServer sends the query to the name server at the address over UDP, and again over TCP if
+// the response is truncated.
+func queryNameServer(ctx context.Context, address, name string, recordType dnsmessage.Type) (*dnsmessage.Message, error) {
+	queryName, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Uint32())},
+		Questions: []dnsmessage.Question{{Name: queryName, Type: recordType, Class: dnsmessage.ClassINET}},
+	}
+	message, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	response, err := exchange(ctx, "udp", address, message, query.Header.ID)
+	if err == nil && response.Truncated {
+		response, err = exchange(ctx, "tcp", address, message, query.Header.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.RCode != dnsmessage.RCodeSuccess && response.RCode != dnsmessage.RCodeNameError {
+		return nil, fmt.Errorf("name server %s answered %s", address, response.RCode)
+	}
+	return response, nil
+}
+
+// exchange sends the DNS message to the address over the network and returns the response.
+func exchange(ctx context.Context, network, address string, message []byte, id uint16) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, nameServerTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	buffer := make([]byte, 65535)
+	var n int
+	if network == "tcp" {
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(message))), message...)); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buffer[:2]); err != nil {
+			return nil, err
+		}
+		n = int(binary.BigEndian.Uint16(buffer[:2]))
+		if _, err := io.ReadFull(conn, buffer[:n]); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(message); err != nil {
+			return nil, err
+		}
+		if n, err = conn.Read(buffer); err != nil {
+			return nil, err
+		}
+	}
+	response := &dnsmessage.Message{}
+	if err := response.Unpack(buffer[:n]); err != nil {
+		return nil, err
+	}
+	if response.ID != id {
+		return nil, errors.New("DNS response does not match the query")
+	}
+	return response, nil
+}
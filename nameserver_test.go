@@ -0,0 +1,186 @@
+package googleclouddns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/api/dns/v1"
+)
+
+// testNameServer is a local DNS server answering over UDP and TCP with the answers of a function.
+type testNameServer struct {
+	address  string
+	queries  atomic.Int32
+	truncate bool
+	answer   func(question dnsmessage.Question, queries int) []dnsmessage.Resource
+}
+
+func startTestNameServer(t *testing.T, truncate bool, answer func(question dnsmessage.Question, queries int) []dnsmessage.Resource) *testNameServer {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skip("unable to listen over TCP on the UDP port:", err)
+	}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+	server := &testNameServer{address: udp.LocalAddr().String(), truncate: truncate, answer: answer}
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			n, addr, err := udp.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if response := server.respond(buffer[:n], server.truncate); response != nil {
+				udp.WriteTo(response, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			buffer := make([]byte, 65535)
+			if _, err := io.ReadFull(conn, buffer[:2]); err == nil {
+				n := int(binary.BigEndian.Uint16(buffer[:2]))
+				if _, err := io.ReadFull(conn, buffer[:n]); err == nil {
+					response := server.respond(buffer[:n], false)
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return server
+}
+
+func (s *testNameServer) respond(message []byte, truncate bool) []byte {
+	query := dnsmessage.Message{}
+	if err := query.Unpack(message); err != nil || len(query.Questions) != 1 {
+		return nil
+	}
+	response := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true, Truncated: truncate},
+		Questions: query.Questions,
+	}
+	if !truncate {
+		response.Answers = s.answer(query.Questions[0], int(s.queries.Add(1)))
+	}
+	data, err := response.Pack()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func txtAnswer(question dnsmessage.Question, text ...string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 60},
+		Body:   &dnsmessage.TXTResource{TXT: text},
+	}
+}
+
+func Test_WaitForRecord(t *testing.T) {
+	pollInterval := changePollInterval
+	changePollInterval = time.Millisecond
+	t.Cleanup(func() {
+		changePollInterval = pollInterval
+	})
+	ctx := context.Background()
+	challenge := libdns.TXT{Name: "_acme-challenge", Text: "challenge"}
+	served := func(question dnsmessage.Question, queries int) []dnsmessage.Resource {
+		if !strings.HasPrefix(question.Name.String(), "_acme-challenge.") || question.Type != dnsmessage.TypeTXT || queries < 3 {
+			return nil
+		}
+		return []dnsmessage.Resource{txtAnswer(question, "other"), txtAnswer(question, "chal", "lenge")}
+	}
+	t.Run("records are waited for on the resolver", func(t *testing.T) {
+		server := startTestNameServer(t, false, served)
+		p, _ := getFakeDNSClient(t)
+		p.ResolverAddress = server.address
+		if err := p.WaitForRecord(ctx, testZone, challenge); err != nil {
+			t.Fatal("error waiting for the record:", err)
+		}
+		if queries := server.queries.Load(); queries != 3 {
+			t.Fatal("expected three queries, received", queries)
+		}
+	})
+	t.Run("records are waited for on the name servers of the zone", func(t *testing.T) {
+		server := startTestNameServer(t, false, served)
+		host, port, _ := net.SplitHostPort(server.address)
+		defaultPort := nameServerPort
+		nameServerPort = port
+		t.Cleanup(func() {
+			nameServerPort = defaultPort
+		})
+		p, fakeServer := getFakeDNSClient(t)
+		fakeServer.AddZone(testProject, &dns.ManagedZone{Name: "local", DnsName: "local.io.", NameServers: []string{host + "."}})
+		if err := p.WaitForRecord(ctx, "local.io.", challenge); err != nil {
+			t.Fatal("error waiting for the record:", err)
+		}
+		if queries := server.queries.Load(); queries != 3 {
+			t.Fatal("expected three queries, received", queries)
+		}
+	})
+	t.Run("truncated responses are queried again over TCP", func(t *testing.T) {
+		server := startTestNameServer(t, true, served)
+		p, _ := getFakeDNSClient(t)
+		p.ResolverAddress = server.address
+		if err := p.WaitForRecord(ctx, testZone, challenge); err != nil {
+			t.Fatal("error waiting for the record:", err)
+		}
+	})
+	t.Run("waiting is limited", func(t *testing.T) {
+		server := startTestNameServer(t, false, func(dnsmessage.Question, int) []dnsmessage.Resource { return nil })
+		p, _ := getFakeDNSClient(t)
+		p.ResolverAddress = server.address
+		p.PropagationTimeout = 20 * time.Millisecond
+		if err := p.WaitForRecord(ctx, testZone, challenge); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expected a deadline exceeded error back, received", err)
+		}
+	})
+}
+
+func Test_AnswerMatches(t *testing.T) {
+	name := dnsmessage.MustNewName("www.libdns.io.")
+	header := func(recordType dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: recordType, Class: dnsmessage.ClassINET}
+	}
+	tests := []struct {
+		answer   dnsmessage.Resource
+		data     string
+		expected bool
+	}{
+		{dnsmessage.Resource{Header: header(dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}}, "127.0.0.1", true},
+		{dnsmessage.Resource{Header: header(dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}}, "127.0.0.2", false},
+		{dnsmessage.Resource{Header: header(dnsmessage.TypeAAAA), Body: &dnsmessage.AAAAResource{AAAA: [16]byte{15: 1}}}, "::1", true},
+		{dnsmessage.Resource{Header: header(dnsmessage.TypeCNAME), Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("Target.libdns.io.")}}, "target.libdns.io", true},
+		{dnsmessage.Resource{Header: header(dnsmessage.TypeMX), Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mail.libdns.io.")}}, "10 mail.libdns.io.", true},
+		{dnsmessage.Resource{Header: header(dnsmessage.TypeMX), Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mail.libdns.io.")}}, "20 mail.libdns.io.", false},
+		{dnsmessage.Resource{Header: header(dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Priority: 1, Weight: 2, Port: 443, Target: dnsmessage.MustNewName("www.libdns.io.")}}, "1 2 443 www.libdns.io.", true},
+		{dnsmessage.Resource{Header: header(257), Body: &dnsmessage.UnknownResource{Type: 257}}, `0 issue "letsencrypt.org"`, true},
+		{dnsmessage.Resource{Header: header(dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}}, "", true},
+	}
+	for _, test := range tests {
+		if answerMatches(test.answer, libdns.RR{Data: test.data}) != test.expected {
+			t.Errorf("expected %v for %s answer and data %q", test.expected, test.answer.Header.Type, test.data)
+		}
+	}
+}
@@ -4,115 +4,188 @@ package googleclouddns
 
 import (
 	"context"
+	"log/slog"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/libdns/libdns"
+	"golang.org/x/oauth2"
 	"google.golang.org/api/dns/v1"
-	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 )
 
 // Provider facilitates DNS record manipulation with Google Cloud DNS.
 type Provider struct {
-	Project            string `json:"gcp_project,omitempty"`
+	Project string `json:"gcp_project,omitempty"`
+	// ServiceAccountJSON is either the path to a service account JSON file or the JSON itself.
 	ServiceAccountJSON string `json:"gcp_application_default,omitempty"`
-	service            *dns.Service
-	zoneMap            map[string]string
-	zoneMapLastUpdated time.Time
-	mutex              sync.Mutex
+	// TokenSource provides the credentials for the Google client and takes precedence over
+	// ServiceAccountJSON.
+	TokenSource oauth2.TokenSource `json:"-"`
+	// ImpersonateServiceAccount is the email of a service account to impersonate with the
+	// credentials above, e.g. "dns-admin@my-project.iam.gserviceaccount.com".
+	ImpersonateServiceAccount string `json:"gcp_impersonate_service_account,omitempty"`
+	// ImpersonateDelegates is the optional chain of service accounts used to reach the
+	// impersonated service account.
+	ImpersonateDelegates []string `json:"gcp_impersonate_delegates,omitempty"`
+	// Endpoint replaces the Cloud DNS API endpoint, e.g. "http://localhost:8080/" for a local
+	// fake server or a Private Service Connect endpoint.
+	Endpoint string `json:"gcp_endpoint,omitempty"`
+	// HTTPClient is used for all the requests to Cloud DNS. It is used as is, so it must
+	// handle authentication itself.
+	HTTPClient *http.Client `json:"-"`
+	// UserAgent is appended to the User-Agent header sent to Cloud DNS.
+	UserAgent string `json:"gcp_user_agent,omitempty"`
+	// Logger receives the warnings of the provider, e.g. records that could not be parsed.
+	// Defaults to slog.Default().
+	Logger *slog.Logger `json:"-"`
+	// ClientOptions are applied after the other options when creating the Google client.
+	ClientOptions []option.ClientOption `json:"-"`
+	// ZoneVisibility selects which managed zones can be used: VisibilityPublic (the default),
+	// VisibilityPrivate, or VisibilityAll.
+	ZoneVisibility string `json:"gcp_zone_visibility,omitempty"`
+	// PreferredVisibility picks the managed zone to use when a public and a private zone share
+	// the same DNS name (split horizon): VisibilityPublic or VisibilityPrivate. Without it, such
+	// zones cannot be used.
+	PreferredVisibility string `json:"gcp_preferred_visibility,omitempty"`
+	// ZoneNames maps DNS names to managed zone names, e.g. {"example.com.": "example-com"}.
+	// Zones found here are used without listing the managed zones in the project.
+	ZoneNames map[string]string `json:"gcp_zone_names,omitempty"`
+	// AutoDiscoverZone accepts any FQDN as the zone and uses the most specific managed zone
+	// containing it. Record names stay relative to the zone passed in.
+	AutoDiscoverZone bool `json:"gcp_auto_discover_zone,omitempty"`
+	// MaxAttempts limits the attempts made by each call to the provider: the calls to Cloud DNS that
+	// are rate limited or fail with a server error, and the changes that conflict with a concurrent
+	// change, share MaxAttempts-1 retries. Defaults to 5, 1 disables retries.
+	MaxAttempts int `json:"gcp_max_attempts,omitempty"`
+	// MaxRetryTime limits the time each call to the provider spends retrying calls to Cloud DNS.
+	// Defaults to one minute.
+	MaxRetryTime time.Duration `json:"gcp_max_retry_time,omitempty"`
+	// Workers is the number of record sets read at the same time when records are appended, set
+	// or deleted. Defaults to 8.
+	Workers int `json:"gcp_workers,omitempty"`
+	// WaitForPropagation makes AppendRecords, SetRecords and DeleteRecords wait until their own change
+	// is applied to the authoritative servers of Cloud DNS before returning. Use WaitForChanges to
+	// wait for every change submitted to a zone.
+	WaitForPropagation bool `json:"gcp_wait_for_propagation,omitempty"`
+	// PropagationTimeout limits the time spent waiting for changes to be applied. Defaults to five
+	// minutes.
+	PropagationTimeout time.Duration `json:"gcp_propagation_timeout,omitempty"`
+	// ResolverAddress is queried by WaitForRecord instead of the name servers of the managed zone,
+	// e.g. "127.0.0.1:5353" for a local DNS server.
+	ResolverAddress string `json:"gcp_resolver_address,omitempty"`
+	// ZoneCacheTTL is the time the managed zones listed in the project are cached for. Zones missing
+	// from the cache are looked up again at most every ten seconds. Defaults to five minutes, a
+	// negative value lists the managed zones for every call.
+	ZoneCacheTTL time.Duration `json:"gcp_zone_cache_ttl,omitempty"`
+	// ShareZoneCache caches the managed zones once for every provider with the same Project, Endpoint,
+	// ServiceAccountJSON and impersonated service account that has it set, instead of once per
+	// provider. Providers authenticating with a TokenSource, an HTTPClient or ClientOptions must only
+	// share the cache if they use the same identity, as it cannot be told from those.
+	ShareZoneCache bool `json:"gcp_share_zone_cache,omitempty"`
+
+	service             *dns.Service
+	serviceMutex        sync.Mutex
+	zoneCache           zoneCache
+	zoneLocks           map[string]*zoneLock
+	zoneLocksMutex      sync.Mutex
+	pendingChanges      map[string][]pendingChange
+	pendingChangesMutex sync.Mutex
+}
+
+// ManagedZone describes a Google Cloud DNS managed zone that is available to the provider.
+type ManagedZone struct {
+	// DNSName is the DNS name of the zone, e.g. "example.com.".
+	DNSName string
+	// Name is the name of the managed zone in Google Cloud DNS, e.g. "example-com".
+	Name string
+	// Visibility is either "public" or "private".
+	Visibility string
 }
 
 // GetRecords lists all the records in the zone.
 func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
 	return p.getCloudDNSRecords(ctx, zone)
 }
 
-// AppendRecords adds records to the zone. It returns the records that were added.
+// AppendRecords adds records to the zone. It returns the records that were added. All the records
+// are submitted as a single Cloud DNS change so either all of them are added or none are.
 func (p *Provider) AppendRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	processedRecords := make(libdnsRecords, 0)
-	recordsToAppend := libdnsRecords(records)
-	for recordData, recordsToPost := range recordsToAppend.groupRecordsByType() {
-		existingRecords, err := p.getCloudDNSRecord(ctx, zone, recordData.name, recordData.recordType)
-		if err != nil {
-			if gErr, ok := err.(*googleapi.Error); !ok || gErr.Code != 404 {
-				return processedRecords, err
-			}
-		}
-		verifiedNewRecords := make(libdnsRecords, 0)
-		for _, newRecord := range recordsToPost { // Make sure that we do not append a record that already exists
-			if existingRecords.doesNotHaveRecord(newRecord) {
-				verifiedNewRecords = append(verifiedNewRecords, newRecord)
-			}
-		}
-		if len(verifiedNewRecords) == 0 {
-			continue
-		}
-		submittedRecords, err := p.postCloudDNSRecord(ctx, zone, append(existingRecords, verifiedNewRecords...))
-		if err != nil {
-			return processedRecords, err
-		}
-		// Let's generate an exact list of appended records based on the returned results
-		for _, updatedRecord := range submittedRecords {
-			if verifiedNewRecords.hasRecord(updatedRecord) {
-				processedRecords = append(processedRecords, updatedRecord)
-			}
-		}
-	}
-	return processedRecords, nil
+	return p.changeCloudDNSRecords(ctx, zone, records, p.appendCloudDNSRecords)
 }
 
 // SetRecords sets the records in the zone, either by updating existing records or creating new ones.
-// It returns the updated records.
+// It returns the updated records. All the records are submitted as a single Cloud DNS change so either
+// all of them are set or none are.
 func (p *Provider) SetRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	processedRecords := make(libdnsRecords, 0)
-	recordsToSet := libdnsRecords(records)
-	for _, recordsToPost := range recordsToSet.groupRecordsByType() {
-		submittedRecords, err := p.postCloudDNSRecord(ctx, zone, recordsToPost)
-		if err != nil {
-			return processedRecords, err
-		}
-		processedRecords = append(processedRecords, submittedRecords...)
-	}
-	return processedRecords, nil
+	return p.changeCloudDNSRecords(ctx, zone, records, p.setCloudDNSRecords)
 }
 
-// DeleteRecords deletes the records from the zone. It returns the records that were deleted.
+// DeleteRecords deletes the records from the zone. It returns the records that were deleted. All the
+// records are submitted as a single Cloud DNS change so either all of them are deleted or none are.
 func (p *Provider) DeleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	recordsToDelete := libdnsRecords(records)
-	deletedRecords := make(libdnsRecords, 0)
-	for recordData, recordsToDelete := range recordsToDelete.groupRecordsByType() {
-		existingRecords, err := p.getCloudDNSRecord(ctx, zone, recordData.name, recordData.recordType)
-		if err != nil { // If the entry does not exist, nothing to delete so skip this set
-			if gErr, ok := err.(*googleapi.Error); !ok || gErr.Code != 404 {
-				return deletedRecords, err
-			}
-			continue
-		}
-		verifiedRecords := make(libdnsRecords, 0)
-		for _, recordToDelete := range recordsToDelete { // Make sure the requested records exist in the Cloud DNS record
-			if existingRecords.hasRecord(recordToDelete) {
-				verifiedRecords = append(verifiedRecords, recordToDelete)
-			}
-		}
-		if len(verifiedRecords) == 0 { // The Cloud DNS entry does not have these records so skip this set
-			continue
-		}
-		processedRecords, err := p.deleteCloudDNSRecord(
-			ctx, zone, recordData.name, recordData.recordType, verifiedRecords, existingRecords)
-		if err != nil {
-			return deletedRecords, err
-		}
-		deletedRecords = append(deletedRecords, processedRecords...)
+	return p.changeCloudDNSRecords(ctx, zone, records, p.deleteCloudDNSRecords)
+}
+
+// WaitForChanges waits until every change submitted to the zone by the provider is applied to the
+// authoritative servers of Cloud DNS, i.e. its status is "done". It polls the status of the changes
+// until ctx is done or the PropagationTimeout is reached.
+func (p *Provider) WaitForChanges(ctx context.Context, zone string) error {
+	if err := p.newService(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := p.withPropagationTimeout(ctx)
+	defer cancel()
+	return p.waitForCloudDNSChanges(ctx, zone)
+}
+
+// ListZones lists all the zones available in the project.
+func (p *Provider) ListZones(ctx context.Context) ([]libdns.Zone, error) {
+	managedZones, err := p.ListManagedZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]libdns.Zone, 0, len(managedZones))
+	for _, managedZone := range managedZones {
+		zones = append(zones, libdns.Zone{Name: managedZone.DNSName})
+	}
+	return zones, nil
+}
+
+// ListManagedZones lists all the zones available in the project along with their
+// Google Cloud DNS details.
+func (p *Provider) ListManagedZones(ctx context.Context) ([]ManagedZone, error) {
+	if err := p.newService(ctx); err != nil {
+		return nil, err
+	}
+	googleZones, err := p.listCloudDNSZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	managedZones := make([]ManagedZone, 0, len(googleZones))
+	for _, googleZone := range googleZones {
+		managedZones = append(managedZones, ManagedZone{
+			DNSName:    googleZone.DnsName,
+			Name:       googleZone.Name,
+			Visibility: googleZone.Visibility,
+		})
+	}
+	return managedZones, nil
+}
+
+// FindZone returns the DNS name of the most specific managed zone containing the specified FQDN
+// along with the name of the FQDN relative to that zone, e.g. "_acme-challenge.foo.example.com."
+// returns "example.com." and "_acme-challenge.foo".
+func (p *Provider) FindZone(ctx context.Context, fqdn string) (string, string, error) {
+	if err := p.newService(ctx); err != nil {
+		return "", "", err
+	}
+	zone, err := p.findCloudDNSZone(ctx, fqdn)
+	if err != nil {
+		return "", "", err
 	}
-	return deletedRecords, nil
+	return zone, libdns.RelativeName(fqdn, zone), nil
 }
 
 // Interface guards
@@ -121,4 +194,5 @@ var (
 	_ libdns.RecordAppender = new(Provider)
 	_ libdns.RecordSetter   = new(Provider)
 	_ libdns.RecordDeleter  = new(Provider)
+	_ libdns.ZoneLister     = new(Provider)
 )
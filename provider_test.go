@@ -1,13 +1,24 @@
 package googleclouddns
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/libdns/googleclouddns/googleclouddnstest"
 	"github.com/libdns/libdns"
-	"google.golang.org/api/googleapi"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/dns/v1"
 )
 
 var (
@@ -63,11 +74,7 @@ func Test_GetRecords(t *testing.T) {
 }
 
 func Test_AppendRecords(t *testing.T) {
-	p, rs, err := getTestDNSClient(`./replay/provider_appendrecords.json`)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer rs.Close()
+	p, _ := getFakeDNSClient(t)
 	recordsToAppend := []libdns.Record{
 		libdns.TXT{
 			Name: "caddy-validation",
@@ -165,11 +172,7 @@ func Test_AppendRecords(t *testing.T) {
 }
 
 func Test_SetRecords(t *testing.T) {
-	p, rs, err := getTestDNSClient(`./replay/provider_setrecords.json`)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer rs.Close()
+	p, _ := getFakeDNSClient(t)
 	t.Run("setting creates new records", func(t *testing.T) {
 		p.Project = testProject
 		recordsToCreate := []libdns.Record{
@@ -225,11 +228,17 @@ func Test_SetRecords(t *testing.T) {
 }
 
 func Test_DeleteRecords(t *testing.T) {
-	p, rs, err := getTestDNSClient(`./replay/provider_deleterecords.json`)
-	if err != nil {
-		t.Fatal(err)
+	p, server := getFakeDNSClient(t)
+	for _, rrs := range []*dns.ResourceRecordSet{
+		{Name: "caddy-validation-mark2.libdns.io.", Type: "TXT", Ttl: 60, Rrdatas: []string{`"I provide new information to the cloud"`}},
+		{Name: "caddy-validation.libdns.io.", Type: "TXT", Ttl: 60, Rrdatas: []string{
+			`"I SHOULD NOT HAVE EXTRA QUOTES"`, `"1234567890abcdef"`, `"I provide new information to the cloud"`,
+		}},
+	} {
+		if err := server.AddRecordSet(testProject, "libdns", rrs); err != nil {
+			t.Fatal(err)
+		}
 	}
-	defer rs.Close()
 	t.Run("delete entire record", func(t *testing.T) {
 		p.Project = testProject
 		recordsToDelete := []libdns.Record{
@@ -362,11 +371,7 @@ func Test_DeleteRecords(t *testing.T) {
 }
 
 func Test_EndToEnd(t *testing.T) {
-	p, rs, err := getTestDNSClient(`./replay/provider_endtoendrecords.json`)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer rs.Close()
+	p, _ := getFakeDNSClient(t)
 	p.Project = testProject
 	requestsOne := []libdns.Record{
 		libdns.TXT{
@@ -428,7 +433,7 @@ func Test_EndToEnd(t *testing.T) {
 
 	txtRecords, err = p.getCloudDNSRecord(context.Background(), testZone, `_acme-challenge`, `TXT`)
 	if err != nil {
-		if googleError, ok := err.(*googleapi.Error); !ok || (ok && googleError.Code != 404) {
+		if !errors.Is(err, ErrRecordNotFound) {
 			t.Fatal("error retrieving TXT record for comparison", err)
 		}
 	}
@@ -436,3 +441,723 @@ func Test_EndToEnd(t *testing.T) {
 		t.Fatal("expectd there to be no records received but received", len(txtRecords))
 	}
 }
+
+func Test_ListZones(t *testing.T) {
+	p, _ := getFakeZonesDNSClient(t)
+	t.Run("list zones available to the provider", func(t *testing.T) {
+		p.Project = testProject
+		zones, err := p.ListZones(context.Background())
+		if err != nil {
+			t.Fatal("error listing zones from the test project:", err)
+		}
+		if len(zones) != 1 {
+			t.Fatal("expected one zone back, received", len(zones))
+		}
+		if zones[0].Name != testZone {
+			t.Fatalf("expected zone '%s', received '%s'", testZone, zones[0].Name)
+		}
+	})
+	t.Run("list managed zones with their Cloud DNS details", func(t *testing.T) {
+		p.Project = testProject
+		zones, err := p.ListManagedZones(context.Background())
+		if err != nil {
+			t.Fatal("error listing managed zones from the test project:", err)
+		}
+		if len(zones) != 1 {
+			t.Fatal("expected one zone back, received", len(zones))
+		}
+		expected := ManagedZone{DNSName: testZone, Name: "libdns", Visibility: "public"}
+		if zones[0] != expected {
+			t.Fatalf("expected managed zone %+v, received %+v", expected, zones[0])
+		}
+	})
+}
+
+func Test_ZoneVisibility(t *testing.T) {
+	tests := []struct {
+		name                string
+		zoneVisibility      string
+		preferredVisibility string
+		zone                string
+		expectedZoneName    string
+	}{
+		{"public zones are used by default", "", "", testZone, "libdns"},
+		{"private zones are ignored by default", "", "", `internal.libdns.io.`, ""},
+		{"private zones are used when requested", VisibilityPrivate, "", `internal.libdns.io.`, "libdns-internal"},
+		{"public zones are ignored for private visibility", VisibilityPrivate, "", testZone, "libdns-private"},
+		{"split horizon zones require a preference", VisibilityAll, "", testZone, ""},
+		{"split horizon zones use the preferred public zone", VisibilityAll, VisibilityPublic, testZone, "libdns"},
+		{"split horizon zones use the preferred private zone", VisibilityAll, VisibilityPrivate, testZone, "libdns-private"},
+		{"private zones are used for all visibilities", VisibilityAll, "", `internal.libdns.io.`, "libdns-internal"},
+		{"unsupported visibilities are rejected", "internal", "", testZone, ""},
+		{"unsupported preferred visibilities are rejected", VisibilityAll, "Private", testZone, ""},
+		{"unsupported preferred visibilities are rejected without split horizon", VisibilityAll, "Private", `internal.libdns.io.`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := getFakeZonesDNSClient(t)
+			p.Project = testProject
+			p.ZoneVisibility = tt.zoneVisibility
+			p.PreferredVisibility = tt.preferredVisibility
+			if err := p.newService(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			zoneName, err := p.getCloudDNSZone(context.Background(), tt.zone)
+			if tt.expectedZoneName == "" {
+				if err == nil {
+					t.Fatalf("expected an error back but received zone '%s'", zoneName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("error finding the managed zone:", err)
+			}
+			if zoneName != tt.expectedZoneName {
+				t.Fatalf("expected managed zone '%s', received '%s'", tt.expectedZoneName, zoneName)
+			}
+		})
+	}
+}
+
+func Test_ZoneNames(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	if err := server.AddRecordSet(testProject, "libdns", &dns.ResourceRecordSet{
+		Name: "hello.libdns.io.", Type: "TXT", Ttl: 300, Rrdatas: []string{`"Hi there! This is a TXT record!"`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("mapped zones do not list the managed zones", func(t *testing.T) {
+		p.Project = testProject
+		p.ZoneNames = map[string]string{testZone: "libdns"}
+		records, err := p.GetRecords(context.Background(), testZone)
+		if err != nil {
+			t.Fatal("error listing records from the mapped zone:", err)
+		}
+		if len(records) != 6 {
+			t.Fatal("expected six records back, received", len(records))
+		}
+		if p.zoneCache.projects != nil {
+			t.Fatal("the managed zones were listed for a mapped zone")
+		}
+	})
+}
+
+func Test_FindZone(t *testing.T) {
+	tests := []struct {
+		name           string
+		zoneVisibility string
+		fqdn           string
+		expectedZone   string
+		expectedName   string
+	}{
+		{"zone apex", "", testZone, testZone, "@"},
+		{"subdomain of a zone", "", `_acme-challenge.foo.libdns.io.`, testZone, "_acme-challenge.foo"},
+		{"private subzones are ignored for public zones", "", `_acme-challenge.internal.libdns.io.`, testZone, "_acme-challenge.internal"},
+		{"delegated subzone is the most specific", VisibilityPrivate, `_acme-challenge.internal.libdns.io.`, `internal.libdns.io.`, "_acme-challenge"},
+		{"domain without a zone", "", `_acme-challenge.example.com.`, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := getFakeZonesDNSClient(t)
+			p.Project = testProject
+			p.ZoneVisibility = tt.zoneVisibility
+			zone, name, err := p.FindZone(context.Background(), tt.fqdn)
+			if tt.expectedZone == "" {
+				if err == nil {
+					t.Fatalf("expected an error back but received zone '%s'", zone)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("error finding the zone:", err)
+			}
+			if zone != tt.expectedZone || name != tt.expectedName {
+				t.Fatalf("expected zone '%s' and name '%s', received '%s' and '%s'", tt.expectedZone, tt.expectedName, zone, name)
+			}
+		})
+	}
+	t.Run("records are relative to a discovered subdomain", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		if err := server.AddRecordSet(testProject, "libdns", &dns.ResourceRecordSet{
+			Name: "hello.libdns.io.", Type: "TXT", Ttl: 300, Rrdatas: []string{`"Hi there! This is a TXT record!"`},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		p.Project = testProject
+		p.ZoneNames = map[string]string{testZone: "libdns"}
+		p.AutoDiscoverZone = true
+		records, err := p.GetRecords(context.Background(), `hello.libdns.io.`)
+		if err != nil {
+			t.Fatal("error listing records from the discovered zone:", err)
+		}
+		if len(records) != 1 {
+			t.Fatal("expected one record back, received", len(records))
+		}
+		if name := records[0].RR().Name; name != "@" {
+			t.Fatalf("expected record named '@', received '%s'", name)
+		}
+	})
+}
+
+func Test_CustomEndpoint(t *testing.T) {
+	userAgent := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		if r.URL.Path != "/dns/v1/projects/test-dev/managedZones" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"managedZones":[{"name":"libdns","dnsName":"libdns.io.","visibility":"public"}]}`))
+	}))
+	defer server.Close()
+	p := Provider{
+		Project:    testProject,
+		Endpoint:   server.URL + "/",
+		HTTPClient: server.Client(),
+		UserAgent:  "libdns-test/1.0",
+	}
+	zones, err := p.ListZones(context.Background())
+	if err != nil {
+		t.Fatal("error listing zones from the custom endpoint:", err)
+	}
+	if len(zones) != 1 || zones[0].Name != testZone {
+		t.Fatalf("expected zone '%s' back, received %+v", testZone, zones)
+	}
+	if !strings.HasSuffix(userAgent, " libdns-test/1.0") {
+		t.Fatalf("expected the user agent to end with the suffix, received '%s'", userAgent)
+	}
+}
+
+func Test_Credentials(t *testing.T) {
+	t.Run("token source is used for the client", func(t *testing.T) {
+		p := Provider{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"})}
+		if err := p.newService(context.Background()); err != nil {
+			t.Fatal("error creating the client from a token source:", err)
+		}
+	})
+	t.Run("impersonated credentials are used for the client", func(t *testing.T) {
+		p := Provider{
+			TokenSource:               oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"}),
+			ImpersonateServiceAccount: "dns-admin@test-dev.iam.gserviceaccount.com",
+			ImpersonateDelegates:      []string{"delegate@test-dev.iam.gserviceaccount.com"},
+		}
+		if err := p.newService(context.Background()); err != nil {
+			t.Fatal("error creating the client with impersonated credentials:", err)
+		}
+	})
+	t.Run("inline service account JSON is parsed", func(t *testing.T) {
+		p := Provider{ServiceAccountJSON: `{"type": "unsupported_credentials"}`}
+		if err := p.newService(context.Background()); err == nil {
+			t.Fatal("expected an error back for unsupported credentials but did not receive one")
+		}
+	})
+	t.Run("service account JSON path is read", func(t *testing.T) {
+		p := Provider{ServiceAccountJSON: `./replay/i-do-not-exist.json`}
+		if err := p.newService(context.Background()); err == nil {
+			t.Fatal("expected an error back for a missing file but did not receive one")
+		}
+	})
+}
+
+func Test_GetRecordsUnparseable(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	logs := &bytes.Buffer{}
+	p.Logger = slog.New(slog.NewTextHandler(logs, nil))
+	for _, rrs := range []*dns.ResourceRecordSet{
+		{Name: "hello.libdns.io.", Type: "TXT", Ttl: 300, Rrdatas: []string{`"Hi there!"`}},
+		{Name: "libdns.io.", Type: "CAA", Ttl: 300, Rrdatas: []string{"not-a-caa-record"}},
+		{Name: "libdns.io.", Type: "ALIAS", Ttl: 300, Rrdatas: []string{"target.example.com."}},
+		{Name: "geo.libdns.io.", Type: "A", Ttl: 300, RoutingPolicy: &dns.RRSetRoutingPolicy{
+			Geo: &dns.RRSetRoutingPolicyGeoPolicy{Items: []*dns.RRSetRoutingPolicyGeoPolicyGeoPolicyItem{
+				{Location: "us-east1", Rrdatas: []string{"127.0.0.1"}},
+			}},
+		}},
+	} {
+		if err := server.AddRecordSet(testProject, "libdns", rrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := p.GetRecords(context.Background(), testZone)
+	if err != nil {
+		t.Fatal("error listing records with unparseable values:", err)
+	}
+	if len(records) != 9 { // NS, SOA and routing policy records included
+		t.Fatal("expected nine records back, received", len(records))
+	}
+	foundCAA := false
+	for _, record := range records {
+		if rr, ok := record.(libdns.RR); ok && rr.Type == "CAA" {
+			foundCAA = rr.Data == "not-a-caa-record"
+		}
+	}
+	if !foundCAA {
+		t.Fatal("expected the unparseable CAA record back as a libdns.RR")
+	}
+	if warnings := strings.Count(logs.String(), "level=WARN"); warnings != 1 {
+		t.Fatalf("expected one warning logged, found %d: %s", warnings, logs.String())
+	}
+}
+
+// blockingTransport holds the requests to a managed zone until it is released.
+type blockingTransport struct {
+	base     http.RoundTripper
+	zone     string
+	once     sync.Once
+	blocked  chan struct{}
+	released chan struct{}
+}
+
+func (b *blockingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if strings.Contains(r.URL.Path, "/managedZones/"+b.zone+"/") {
+		b.once.Do(func() { close(b.blocked) })
+		<-b.released
+	}
+	return b.base.RoundTrip(r)
+}
+
+func Test_ConcurrentZones(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	server.AddZone(testProject, &dns.ManagedZone{Name: "slow", DnsName: "slow.io."})
+	transport := &blockingTransport{
+		base:     server.Client().Transport,
+		zone:     "slow",
+		blocked:  make(chan struct{}),
+		released: make(chan struct{}),
+	}
+	p.HTTPClient = &http.Client{Transport: transport}
+	ctx := context.Background()
+	challenge := []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "challenge", TTL: time.Minute}}
+	slowErr := make(chan error)
+	go func() {
+		_, err := p.AppendRecords(ctx, "slow.io.", challenge)
+		slowErr <- err
+	}()
+	<-transport.blocked // the slow zone is now waiting on Cloud DNS
+	done := make(chan error)
+	go func() {
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			done <- err
+			return
+		}
+		_, err := p.AppendRecords(ctx, testZone, challenge)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("error updating the test zone:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the test zone was blocked by the slow zone")
+	}
+	close(transport.released)
+	if err := <-slowErr; err != nil {
+		t.Fatal("error updating the slow zone:", err)
+	}
+}
+
+func Test_ConcurrentChanges(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.AppendRecords(ctx, testZone, []libdns.Record{
+				libdns.TXT{Name: "_acme-challenge", Text: fmt.Sprint("challenge-", i), TTL: time.Minute},
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal("error appending records:", err)
+		}
+	}
+	rrs := server.RecordSet(testProject, "libdns", "_acme-challenge.libdns.io.", "TXT")
+	if rrs == nil || len(rrs.Rrdatas) != 10 {
+		t.Fatalf("expected ten values in the record set, found %+v", rrs)
+	}
+}
+
+func Test_ConcurrentDiscoveredZones(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	p.AutoDiscoverZone = true
+	transport := &changesTransport{base: server.Client().Transport}
+	p.HTTPClient = &http.Client{Transport: transport}
+	server.SetLatency(5 * time.Millisecond)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		zone, name := testZone, "_acme-challenge.sub"
+		if i%2 == 0 {
+			zone, name = "sub."+testZone, "_acme-challenge"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.AppendRecords(ctx, zone, []libdns.Record{
+				libdns.TXT{Name: name, Text: fmt.Sprint("challenge-", i), TTL: time.Minute},
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal("error appending records:", err)
+		}
+	}
+	if posts := transport.posts.Load(); posts != 10 {
+		t.Fatal("expected the changes to the managed zone to be serialized, submitted", posts)
+	}
+}
+
+func Test_ZoneLocks(t *testing.T) {
+	p := &Provider{}
+	locked := make(chan []func())
+	go func() { // a call holding the lock of a managed zone never waits for other managed zones
+		unlocks := make([]func(), 0)
+		for i := 0; i < 200; i++ {
+			unlocks = append(unlocks, p.lockZone(fmt.Sprint("zone-", i)))
+		}
+		locked <- unlocks
+	}()
+	var unlocks []func()
+	select {
+	case unlocks = <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("locking a managed zone waited for another managed zone")
+	}
+	for _, unlock := range unlocks {
+		unlock()
+	}
+	if len(p.zoneLocks) != 0 {
+		t.Fatal("expected the unused locks to be forgotten, found", len(p.zoneLocks))
+	}
+}
+
+func Test_ConcurrentRecordSets(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	p.Workers = 4
+	ctx := context.Background()
+	records := make([]libdns.Record, 0)
+	for i := 0; i < 20; i++ {
+		records = append(records, libdns.TXT{Name: fmt.Sprintf("name-%02d", i), Text: "challenge", TTL: time.Minute})
+	}
+	appended, err := p.AppendRecords(ctx, testZone, records)
+	if err != nil {
+		t.Fatal("error appending records:", err)
+	}
+	for i, record := range appended {
+		if record.RR().Name != records[i].RR().Name {
+			t.Fatalf("expected the records in order, found %s at %d", record.RR().Name, i)
+		}
+	}
+	deleted, err := p.DeleteRecords(ctx, testZone, records)
+	if err != nil {
+		t.Fatal("error deleting records:", err)
+	}
+	if len(deleted) != len(records) || len(server.Changes(testProject, "libdns")) != 2 {
+		t.Fatal("expected all the records to be deleted in a single change")
+	}
+	t.Run("failed reads stop the change", func(t *testing.T) {
+		server.InjectFault(googleclouddnstest.Fault{Method: http.MethodGet, Resource: "rrsets", Code: http.StatusForbidden, Count: 1})
+		if _, err := p.AppendRecords(ctx, testZone, records); !errors.Is(err, ErrPermissionDenied) {
+			t.Fatal("expected a permission denied error back, received", err)
+		}
+		if len(server.Changes(testProject, "libdns")) != 2 {
+			t.Fatal("expected no change to be submitted")
+		}
+	})
+}
+
+// nameListingTransport counts the listings of the record sets at a name, and the most of them running
+// at the same time.
+type nameListingTransport struct {
+	base     http.RoundTripper
+	listings atomic.Int32
+	running  atomic.Int32
+	most     atomic.Int32
+}
+
+func (n *nameListingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/rrsets") || r.URL.Query().Get("name") == "" {
+		return n.base.RoundTrip(r)
+	}
+	n.listings.Add(1)
+	running := n.running.Add(1)
+	defer n.running.Add(-1)
+	for {
+		most := n.most.Load()
+		if running <= most || n.most.CompareAndSwap(most, running) {
+			break
+		}
+	}
+	return n.base.RoundTrip(r)
+}
+
+func Test_ConcurrentTypelessDeletions(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	p.Workers = 4
+	transport := &nameListingTransport{base: server.Client().Transport}
+	p.HTTPClient = &http.Client{Transport: transport}
+	server.SetLatency(5 * time.Millisecond)
+	ctx := context.Background()
+	records := make([]libdns.Record, 0)
+	toDelete := make([]libdns.Record, 0)
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("name-%02d", i)
+		records = append(records, libdns.TXT{Name: name, Text: "challenge", TTL: time.Minute})
+		toDelete = append(toDelete, libdns.RR{Name: name}, libdns.RR{Name: name})
+	}
+	if _, err := p.AppendRecords(ctx, testZone, records); err != nil {
+		t.Fatal("error appending records:", err)
+	}
+	deleted, err := p.DeleteRecords(ctx, testZone, toDelete)
+	if err != nil {
+		t.Fatal("error deleting records:", err)
+	}
+	if len(deleted) != len(records) {
+		t.Fatalf("expected %d records deleted, received %d", len(records), len(deleted))
+	}
+	if listings := transport.listings.Load(); listings != int32(len(records)) {
+		t.Fatalf("expected each name to be listed once, listed %d times", listings)
+	}
+	if most := transport.most.Load(); most < 2 || most > int32(p.Workers) {
+		t.Fatalf("expected the names to be listed by up to %d workers, listed %d at the same time", p.Workers, most)
+	}
+}
+
+func Benchmark_AppendAndDeleteRecords(b *testing.B) {
+	records := make([]libdns.Record, 0)
+	for i := 0; i < 50; i++ {
+		records = append(records, libdns.TXT{Name: fmt.Sprintf("name-%02d", i), Text: "challenge", TTL: time.Minute})
+	}
+	for _, workers := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			server := googleclouddnstest.NewServer()
+			defer server.Close()
+			server.AddZone(testProject, &dns.ManagedZone{Name: "libdns", DnsName: testZone})
+			server.SetLatency(5 * time.Millisecond)
+			p := Provider{
+				Project:    testProject,
+				Endpoint:   server.Endpoint(),
+				HTTPClient: server.Client(),
+				Workers:    workers,
+			}
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := p.AppendRecords(ctx, testZone, records); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := p.DeleteRecords(ctx, testZone, records); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Test_WaitForChanges(t *testing.T) {
+	pollInterval := changePollInterval
+	changePollInterval = time.Millisecond
+	t.Cleanup(func() {
+		changePollInterval = pollInterval
+	})
+	ctx := context.Background()
+	challenge := []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "challenge", TTL: time.Minute}}
+	lastChangeStatus := func(server *googleclouddnstest.Server) string {
+		changes := server.Changes(testProject, "libdns")
+		return changes[len(changes)-1].Status
+	}
+	t.Run("changes are waited for when set", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		p.WaitForPropagation = true
+		server.SetPendingPolls(3)
+		if _, err := p.AppendRecords(ctx, testZone, challenge); err != nil {
+			t.Fatal("error appending records:", err)
+		}
+		if status := lastChangeStatus(server); status != "done" {
+			t.Fatal("expected the change to be done, found", status)
+		}
+	})
+	t.Run("pending changes are waited for", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		server.SetPendingPolls(3)
+		if _, err := p.AppendRecords(ctx, testZone, challenge); err != nil {
+			t.Fatal("error appending records:", err)
+		}
+		if _, err := p.DeleteRecords(ctx, testZone, challenge); err != nil {
+			t.Fatal("error deleting records:", err)
+		}
+		if status := lastChangeStatus(server); status != "pending" {
+			t.Fatal("expected the change to be pending, found", status)
+		}
+		if err := p.WaitForChanges(ctx, testZone); err != nil {
+			t.Fatal("error waiting for the changes:", err)
+		}
+		for _, change := range server.Changes(testProject, "libdns") {
+			if change.Status != "done" {
+				t.Fatalf("expected change %s to be done, found %s", change.Id, change.Status)
+			}
+		}
+		if err := p.WaitForChanges(ctx, testZone); err != nil {
+			t.Fatal("error waiting without pending changes:", err)
+		}
+	})
+	t.Run("changes only wait for themselves", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		server.SetPendingPolls(1000000)
+		if _, err := p.AppendRecords(ctx, testZone, challenge); err != nil {
+			t.Fatal("error appending records:", err)
+		}
+		p.WaitForPropagation = true
+		p.PropagationTimeout = 5 * time.Second
+		server.SetPendingPolls(3)
+		other := []libdns.Record{libdns.TXT{Name: "_acme-challenge.other", Text: "challenge", TTL: time.Minute}}
+		if _, err := p.AppendRecords(ctx, testZone, other); err != nil {
+			t.Fatal("error appending records while another change is pending:", err)
+		}
+		changes := server.Changes(testProject, "libdns")
+		if status := changes[len(changes)-2].Status; status != "pending" {
+			t.Fatal("expected the first change to still be pending, found", status)
+		}
+		if status := lastChangeStatus(server); status != "done" {
+			t.Fatal("expected the change to be done, found", status)
+		}
+	})
+	t.Run("changes no longer found are forgotten", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		server.SetPendingPolls(3)
+		if _, err := p.AppendRecords(ctx, testZone, challenge); err != nil {
+			t.Fatal("error appending records:", err)
+		}
+		server.InjectFault(googleclouddnstest.Fault{Method: http.MethodGet, Resource: "changes", Code: http.StatusNotFound, Count: 1})
+		if err := p.WaitForChanges(ctx, testZone); err != nil {
+			t.Fatal("error waiting for a change no longer found:", err)
+		}
+		if pending := p.getPendingCloudDNSChanges(testZone); len(pending) != 0 {
+			t.Fatal("expected the change to be forgotten, found", pending)
+		}
+	})
+	t.Run("waiting is limited", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		p.WaitForPropagation = true
+		p.PropagationTimeout = 20 * time.Millisecond
+		server.SetPendingPolls(1000000)
+		records, err := p.AppendRecords(ctx, testZone, challenge)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expected a deadline exceeded error back, received", err)
+		}
+		if len(records) != 1 {
+			t.Fatal("expected the appended record back along with the error, received", len(records))
+		}
+	})
+}
+
+func Test_ZoneDiscoveryCancellation(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	server.SetLatency(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.GetRecords(ctx, testZone); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected a deadline exceeded error back, received", err)
+	}
+	if _, _, err := p.FindZone(ctx, "_acme-challenge."+testZone); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected a deadline exceeded error back, received", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("listing the managed zones was not interrupted")
+	}
+	server.SetLatency(0)
+	if _, err := p.GetRecords(context.Background(), testZone); err != nil {
+		t.Fatal("error listing records after the cancelled listing:", err)
+	}
+}
+
+func Test_DeleteRecordsWildcards(t *testing.T) {
+	ctx := context.Background()
+	records := []libdns.Record{
+		libdns.TXT{Name: "_acme-challenge", Text: "one", TTL: time.Minute},
+		libdns.TXT{Name: "_acme-challenge", Text: "two", TTL: time.Minute},
+		libdns.Address{Name: "_acme-challenge", IP: netip.MustParseAddr("127.0.0.1"), TTL: time.Minute},
+		libdns.TXT{Name: "@", Text: "apex", TTL: time.Minute},
+	}
+	tests := []struct {
+		name      string
+		delete    []libdns.Record
+		deleted   int
+		remaining map[string]int // values left per "name type"
+	}{
+		{"every type at a name", []libdns.Record{libdns.RR{Name: "_acme-challenge"}}, 3,
+			map[string]int{"_acme-challenge TXT": 0, "_acme-challenge A": 0, "@ TXT": 1}},
+		{"every value of a type", []libdns.Record{libdns.TXT{Name: "_acme-challenge"}}, 2,
+			map[string]int{"_acme-challenge TXT": 0, "_acme-challenge A": 1}},
+		{"every value with the TTL", []libdns.Record{libdns.RR{Name: "_acme-challenge", Type: "TXT", TTL: time.Minute}}, 2,
+			map[string]int{"_acme-challenge TXT": 0, "_acme-challenge A": 1}},
+		{"nothing with another TTL", []libdns.Record{libdns.RR{Name: "_acme-challenge", Type: "TXT", TTL: time.Hour}}, 0,
+			map[string]int{"_acme-challenge TXT": 2}},
+		{"nothing with another TTL and value", []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "one", TTL: time.Hour}}, 0,
+			map[string]int{"_acme-challenge TXT": 2}},
+		{"a value of any TTL", []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "one"}}, 1,
+			map[string]int{"_acme-challenge TXT": 1}},
+		{"every type at the apex but SOA and NS", []libdns.Record{libdns.RR{Name: "@"}}, 1,
+			map[string]int{"@ TXT": 0, "@ SOA": 1, "@ NS": 4, "_acme-challenge TXT": 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, _ := getFakeDNSClient(t)
+			if _, err := p.AppendRecords(ctx, testZone, records); err != nil {
+				t.Fatal("error appending records:", err)
+			}
+			deleted, err := p.DeleteRecords(ctx, testZone, test.delete)
+			if err != nil {
+				t.Fatal("error deleting records:", err)
+			}
+			if len(deleted) != test.deleted {
+				t.Fatalf("expected %d records deleted, received %v", test.deleted, deleted)
+			}
+			for _, record := range deleted {
+				if _, ok := record.(libdns.RR); ok {
+					t.Fatalf("expected the deleted records to be typed, received %#v", record)
+				}
+			}
+			remaining, err := p.GetRecords(ctx, testZone)
+			if err != nil {
+				t.Fatal("error listing records:", err)
+			}
+			counts := make(map[string]int)
+			for _, record := range remaining {
+				counts[record.RR().Name+" "+record.RR().Type]++
+			}
+			for key, count := range test.remaining {
+				if counts[key] != count {
+					t.Fatalf("expected %d %s records left, found %d", count, key, counts[key])
+				}
+			}
+		})
+	}
+	t.Run("routing policies of a type", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		if _, err := p.SetGeoRecords(ctx, testZone, "geo", "A", time.Minute, map[string][]string{"us-east1": {"10.0.0.1"}}); err != nil {
+			t.Fatal("error setting the routing policy:", err)
+		}
+		deleted, err := p.DeleteRecords(ctx, testZone, []libdns.Record{libdns.RR{Name: "geo", Type: "A"}})
+		if err != nil {
+			t.Fatal("error deleting records:", err)
+		}
+		if len(deleted) != 1 || server.RecordSet(testProject, "libdns", "geo."+testZone, "A") != nil {
+			t.Fatalf("expected the routing policy to be deleted, received %v", deleted)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package googleclouddns
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/libdns/libdns"
+)
+
+// svcParamKeys orders the SvcParams by their RFC 9460 key numbers.
+var svcParamKeys = map[string]int{
+	"mandatory":       0,
+	"alpn":            1,
+	"no-default-alpn": 2,
+	"port":            3,
+	"ipv4hint":        4,
+	"ech":             5,
+	"ipv6hint":        6,
+}
+
+// encodeRdata formats the unescaped libdns data of the record type the way Cloud DNS stores it. The
+// targets of CNAME, ALIAS, NS, PTR, MX, SRV, SVCB, HTTPS and NAPTR records are made fully qualified,
+// CAA values and TXT strings are quoted, SvcParams are ordered by key and hexadecimal digests are
+// uppercased. Data that cannot be parsed is returned as is for Cloud DNS to reject it.
+func encodeRdata(recordType, data string) string {
+	switch recordType {
+	case "TXT", "SPF":
+		return encodeTXT(data)
+	case "CNAME", "NS", "PTR", "ALIAS":
+		return absoluteTarget(data)
+	case "MX":
+		if fields := strings.Fields(data); len(fields) == 2 {
+			return fields[0] + " " + absoluteTarget(fields[1])
+		}
+	case "SRV":
+		if fields := strings.Fields(data); len(fields) == 4 {
+			return strings.Join(fields[:3], " ") + " " + absoluteTarget(fields[3])
+		}
+	case "CAA":
+		if flags, tag, value, err := parseCAA(data); err == nil {
+			return fmt.Sprintf("%d %s %s", flags, tag, quoteCharacterString(value))
+		}
+	case "SVCB", "HTTPS":
+		if priority, target, params, err := parseServiceBinding(data); err == nil {
+			return strings.TrimSpace(fmt.Sprintf("%d %s %s", priority, absoluteTarget(target), formatSvcParams(params)))
+		}
+	case "DS":
+		if ds, err := parseDS(libdns.RR{Data: data}); err == nil {
+			ds.Digest = strings.ToUpper(ds.Digest)
+			return ds.RR().Data
+		}
+	case "TLSA":
+		if tlsa, err := parseTLSA(libdns.RR{Data: data}); err == nil {
+			tlsa.Certificate = strings.ToUpper(tlsa.Certificate)
+			return tlsa.RR().Data
+		}
+	case "SSHFP":
+		if sshfp, err := parseSSHFP(libdns.RR{Data: data}); err == nil {
+			sshfp.Fingerprint = strings.ToUpper(sshfp.Fingerprint)
+			return sshfp.RR().Data
+		}
+	case "NAPTR":
+		if naptr, err := parseNAPTR(libdns.RR{Data: data}); err == nil {
+			naptr.Replacement = absoluteTarget(naptr.Replacement)
+			return naptr.RR().Data
+		}
+	}
+	return data
+}
+
+// decodeRdata returns the unescaped libdns data of the record type from the data stored by Cloud DNS.
+// Data that cannot be decoded is returned as is along with an error.
+func decodeRdata(recordType, rrdata string) (string, error) {
+	switch recordType {
+	case "TXT", "SPF":
+		text, err := decodeTXT(rrdata)
+		if err != nil {
+			return rrdata, err
+		}
+		return text, nil
+	case "CAA":
+		fields := strings.SplitN(strings.TrimSpace(rrdata), " ", 3)
+		if len(fields) != 3 {
+			return rrdata, fmt.Errorf(`malformed CAA value %q, expected 'flags tag "value"'`, rrdata)
+		}
+		flags, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			return rrdata, fmt.Errorf("invalid CAA flags %s: %w", fields[0], err)
+		}
+		value, err := decodeTXT(fields[2])
+		if err != nil {
+			return rrdata, err
+		}
+		return libdns.CAA{Flags: uint8(flags), Tag: fields[1], Value: value}.RR().Data, nil
+	}
+	return rrdata, nil
+}
+
+// parseRdata parses the record into its libdns type, or into the types of the provider for the
+// records libdns has no type for. CAA values holding spaces or escapes are parsed here as libdns
+// splits the data on spaces.
+func parseRdata(rr libdns.RR) (libdns.Record, error) {
+	switch rr.Type {
+	case "CAA":
+		flags, tag, value, err := parseCAA(rr.Data)
+		if err != nil {
+			return nil, err
+		}
+		return libdns.CAA{Name: rr.Name, TTL: rr.TTL, Flags: flags, Tag: tag, Value: value}, nil
+	case "ALIAS":
+		return ALIAS{Name: rr.Name, TTL: rr.TTL, Target: rr.Data}, nil
+	case "DS":
+		return parseDS(rr)
+	case "TLSA":
+		return parseTLSA(rr)
+	case "SSHFP":
+		return parseSSHFP(rr)
+	case "NAPTR":
+		return parseNAPTR(rr)
+	}
+	return rr.Parse()
+}
+
+// sameRdata returns true if both libdns data of the record type are stored the same by Cloud DNS.
+func sameRdata(recordType, a, b string) bool {
+	return a == b || encodeRdata(recordType, a) == encodeRdata(recordType, b)
+}
+
+// absoluteTarget returns the target name with a trailing dot, libdns targets being fully qualified
+// with or without one.
+func absoluteTarget(target string) string {
+	if target == "" || strings.HasSuffix(target, ".") {
+		return target
+	}
+	return target + "."
+}
+
+// parseCAA parses the libdns data of a CAA record, i.e. 'flags tag "value"' with the value quoted the
+// way Go quotes strings.
+func parseCAA(data string) (uint8, string, string, error) {
+	fields := strings.SplitN(strings.TrimSpace(data), " ", 3)
+	if len(fields) != 3 {
+		return 0, "", "", fmt.Errorf(`malformed CAA value %q, expected 'flags tag "value"'`, data)
+	}
+	flags, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("invalid CAA flags %s: %w", fields[0], err)
+	}
+	value, err := strconv.Unquote(fields[2])
+	if err != nil { // not quoted by libdns
+		value = strings.Trim(fields[2], `"`)
+	}
+	return uint8(flags), fields[1], value, nil
+}
+
+// parseServiceBinding parses the data of a SVCB or HTTPS record, i.e. "priority target [SvcParams]".
+func parseServiceBinding(data string) (uint16, string, libdns.SvcParams, error) {
+	fields := strings.SplitN(strings.TrimSpace(data), " ", 3)
+	if len(fields) < 2 {
+		return 0, "", nil, fmt.Errorf("malformed service binding %q, expected 'priority target [SvcParams]'", data)
+	}
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("invalid service binding priority %s: %w", fields[0], err)
+	}
+	params := libdns.SvcParams{}
+	if len(fields) == 3 {
+		if params, err = libdns.ParseSvcParams(fields[2]); err != nil {
+			return 0, "", nil, err
+		}
+	}
+	return uint16(priority), fields[1], params, nil
+}
+
+// formatSvcParams formats the SvcParams ordered by key number, as libdns leaves them in map order.
+func formatSvcParams(params libdns.SvcParams) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(svcParamKeyNumber(a), svcParamKeyNumber(b)), cmp.Compare(a, b))
+	})
+	formatted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		formatted = append(formatted, libdns.SvcParams{key: params[key]}.String())
+	}
+	return strings.Join(formatted, " ")
+}
+
+// svcParamKeyNumber returns the number of the SvcParam key, either a registered key name or keyNNNNN.
+func svcParamKeyNumber(key string) int {
+	if number, ok := svcParamKeys[key]; ok {
+		return number
+	}
+	if number, err := strconv.ParseUint(strings.TrimPrefix(key, "key"), 10, 16); err == nil && strings.HasPrefix(key, "key") {
+		return int(number)
+	}
+	return 1 << 16
+}
@@ -0,0 +1,230 @@
+package googleclouddns
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// richRecordTests are typed records, from libdns or the provider, along with the data Cloud DNS stores
+// for them and the record read back from it, when it differs from the record sent.
+var richRecordTests = []struct {
+	name     string
+	record   libdns.Record
+	fqdn     string
+	rrdata   string
+	readBack libdns.Record
+}{
+	{
+		name:   "MX",
+		record: libdns.MX{Name: "@", TTL: time.Hour, Preference: 10, Target: "mail.libdns.io."},
+		fqdn:   "libdns.io.",
+		rrdata: "10 mail.libdns.io.",
+	},
+	{
+		name:     "MX without a trailing dot",
+		record:   libdns.MX{Name: "mx", TTL: time.Hour, Preference: 0, Target: "mail.example.com"},
+		fqdn:     "mx.libdns.io.",
+		rrdata:   "0 mail.example.com.",
+		readBack: libdns.MX{Name: "mx", TTL: time.Hour, Preference: 0, Target: "mail.example.com."},
+	},
+	{
+		name:   "SRV at the apex",
+		record: libdns.SRV{Service: "sip", Transport: "tcp", Name: "@", TTL: time.Hour, Priority: 10, Weight: 5, Port: 5060, Target: "sip.libdns.io."},
+		fqdn:   "_sip._tcp.libdns.io.",
+		rrdata: "10 5 5060 sip.libdns.io.",
+	},
+	{
+		name:     "SRV without a trailing dot",
+		record:   libdns.SRV{Service: "xmpp", Transport: "tcp", Name: "chat", TTL: time.Hour, Priority: 0, Weight: 0, Port: 5222, Target: "xmpp.example.com"},
+		fqdn:     "_xmpp._tcp.chat.libdns.io.",
+		rrdata:   "0 0 5222 xmpp.example.com.",
+		readBack: libdns.SRV{Service: "xmpp", Transport: "tcp", Name: "chat", TTL: time.Hour, Priority: 0, Weight: 0, Port: 5222, Target: "xmpp.example.com."},
+	},
+	{
+		name:   "SRV without a service",
+		record: libdns.SRV{Service: "ldap", Transport: "tcp", Name: "@", TTL: time.Hour, Priority: 0, Weight: 0, Port: 0, Target: "."},
+		fqdn:   "_ldap._tcp.libdns.io.",
+		rrdata: "0 0 0 .",
+	},
+	{
+		name:   "CAA",
+		record: libdns.CAA{Name: "@", TTL: time.Hour, Flags: 0, Tag: "issue", Value: "letsencrypt.org"},
+		fqdn:   "libdns.io.",
+		rrdata: `0 issue "letsencrypt.org"`,
+	},
+	{
+		name:   "CAA with parameters",
+		record: libdns.CAA{Name: "@", TTL: time.Hour, Flags: 128, Tag: "issue", Value: "letsencrypt.org; validationmethods=dns-01"},
+		fqdn:   "libdns.io.",
+		rrdata: `128 issue "letsencrypt.org; validationmethods=dns-01"`,
+	},
+	{
+		name:   "CAA with quotes",
+		record: libdns.CAA{Name: "@", TTL: time.Hour, Flags: 0, Tag: "iodef", Value: `mailto:"security"@libdns.io`},
+		fqdn:   "libdns.io.",
+		rrdata: `0 iodef "mailto:\"security\"@libdns.io"`,
+	},
+	{
+		name:   "CAA forbidding wildcards",
+		record: libdns.CAA{Name: "@", TTL: time.Hour, Flags: 0, Tag: "issuewild", Value: ";"},
+		fqdn:   "libdns.io.",
+		rrdata: `0 issuewild ";"`,
+	},
+	{
+		name: "HTTPS",
+		record: libdns.ServiceBinding{Scheme: "https", Name: "@", TTL: time.Hour, Priority: 1, Target: ".", Params: libdns.SvcParams{
+			"ipv6hint": {"::1"},
+			"ipv4hint": {"127.0.0.1", "127.0.0.2"},
+			"alpn":     {"h2", "h3"},
+			"ech":      {"AEn+DQBFKwAgACABWIHUGj4u"},
+		}},
+		fqdn:   "libdns.io.",
+		rrdata: "1 . alpn=h2,h3 ipv4hint=127.0.0.1,127.0.0.2 ech=AEn+DQBFKwAgACABWIHUGj4u ipv6hint=::1",
+	},
+	{
+		name:   "HTTPS in alias mode",
+		record: libdns.ServiceBinding{Scheme: "https", Name: "www", TTL: time.Hour, Priority: 0, Target: "cdn.example.com", Params: libdns.SvcParams{}},
+		fqdn:   "www.libdns.io.",
+		rrdata: "0 cdn.example.com.",
+		readBack: libdns.ServiceBinding{Scheme: "https", Name: "www", TTL: time.Hour, Priority: 0, Target: "cdn.example.com.",
+			Params: libdns.SvcParams{}},
+	},
+	{
+		name: "SVCB on a port",
+		record: libdns.ServiceBinding{Scheme: "dns", URLSchemePort: 853, Name: "@", TTL: time.Hour, Priority: 1, Target: "dns.libdns.io.", Params: libdns.SvcParams{
+			"key65000":  {"custom"},
+			"alpn":      {"dot"},
+			"port":      {"853"},
+			"mandatory": {"alpn"},
+		}},
+		fqdn:   "_853._dns.libdns.io.",
+		rrdata: "1 dns.libdns.io. mandatory=alpn alpn=dot port=853 key65000=custom",
+	},
+	{
+		name:   "NS",
+		record: libdns.NS{Name: "sub", TTL: time.Hour, Target: "ns-cloud-a1.googledomains.com."},
+		fqdn:   "sub.libdns.io.",
+		rrdata: "ns-cloud-a1.googledomains.com.",
+	},
+	{
+		name:   "CNAME",
+		record: libdns.CNAME{Name: "www", TTL: time.Hour, Target: "libdns.io."},
+		fqdn:   "www.libdns.io.",
+		rrdata: "libdns.io.",
+	},
+	{
+		name:     "CNAME without a trailing dot",
+		record:   libdns.CNAME{Name: "docs", TTL: time.Hour, Target: "libdns.github.io"},
+		fqdn:     "docs.libdns.io.",
+		rrdata:   "libdns.github.io.",
+		readBack: libdns.CNAME{Name: "docs", TTL: time.Hour, Target: "libdns.github.io."},
+	},
+	{
+		name:   "TXT",
+		record: libdns.TXT{Name: "@", TTL: time.Hour, Text: `v=spf1 include:_spf.google.com ~all`},
+		fqdn:   "libdns.io.",
+		rrdata: `"v=spf1 include:_spf.google.com ~all"`,
+	},
+	{
+		name:   "ALIAS",
+		record: ALIAS{Name: "@", TTL: time.Hour, Target: "lb.example.com."},
+		fqdn:   "libdns.io.",
+		rrdata: "lb.example.com.",
+	},
+	{
+		name:   "DS",
+		record: DS{Name: "child", TTL: time.Hour, KeyTag: 12345, Algorithm: 13, DigestType: 2, Digest: "2BB183AF5F22588179A53B0A98631FAD1A292118A292118A292118A2921181D"},
+		fqdn:   "child.libdns.io.",
+		rrdata: "12345 13 2 2BB183AF5F22588179A53B0A98631FAD1A292118A292118A292118A2921181D",
+	},
+	{
+		name:     "DS in lowercase",
+		record:   DS{Name: "other", TTL: time.Hour, KeyTag: 1, Algorithm: 8, DigestType: 1, Digest: "2bb183af5f22588179a53b0a98631fad1a292118"},
+		fqdn:     "other.libdns.io.",
+		rrdata:   "1 8 1 2BB183AF5F22588179A53B0A98631FAD1A292118",
+		readBack: DS{Name: "other", TTL: time.Hour, KeyTag: 1, Algorithm: 8, DigestType: 1, Digest: "2BB183AF5F22588179A53B0A98631FAD1A292118"},
+	},
+	{
+		name:   "TLSA",
+		record: TLSA{Name: "_443._tcp.www", TTL: time.Hour, Usage: 3, Selector: 1, MatchingType: 1, Certificate: "0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6"},
+		fqdn:   "_443._tcp.www.libdns.io.",
+		rrdata: "3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6",
+	},
+	{
+		name:   "SSHFP",
+		record: SSHFP{Name: "host", TTL: time.Hour, Algorithm: 4, FingerprintType: 2, Fingerprint: "123456789ABCDEF67890123456789ABCDEF67890123456789ABCDEF123456789A"},
+		fqdn:   "host.libdns.io.",
+		rrdata: "4 2 123456789ABCDEF67890123456789ABCDEF67890123456789ABCDEF123456789A",
+	},
+	{
+		name:   "NAPTR with a regular expression",
+		record: NAPTR{Name: "enum", TTL: time.Hour, Order: 100, Preference: 10, Flags: "u", Service: "E2U+sip", Regexp: `!^\+1(.*)$!sip:\1@libdns.io!`, Replacement: "."},
+		fqdn:   "enum.libdns.io.",
+		rrdata: `100 10 "u" "E2U+sip" "!^\\+1(.*)$!sip:\\1@libdns.io!" .`,
+	},
+	{
+		name:     "NAPTR with a replacement",
+		record:   NAPTR{Name: "sip", TTL: time.Hour, Order: 10, Preference: 0, Flags: "s", Service: "SIP+D2U", Replacement: "_sip._udp.libdns.io"},
+		fqdn:     "sip.libdns.io.",
+		rrdata:   `10 0 "s" "SIP+D2U" "" _sip._udp.libdns.io.`,
+		readBack: NAPTR{Name: "sip", TTL: time.Hour, Order: 10, Preference: 0, Flags: "s", Service: "SIP+D2U", Replacement: "_sip._udp.libdns.io."},
+	},
+}
+
+func Test_RichRecordConversion(t *testing.T) {
+	for _, test := range richRecordTests {
+		t.Run(test.name, func(t *testing.T) {
+			rrs, err := libdnsRecords{test.record}.toResourceRecordSet(testZone)
+			if err != nil {
+				t.Fatal("error converting the record:", err)
+			}
+			if rrs.Name != test.fqdn || len(rrs.Rrdatas) != 1 || rrs.Rrdatas[0] != test.rrdata {
+				t.Fatalf("expected %s with data %q, received %s with %q", test.fqdn, test.rrdata, rrs.Name, rrs.Rrdatas)
+			}
+			records, warnings := convertToLibDNS(rrs, testZone)
+			if len(warnings) != 0 {
+				t.Fatal("unexpected warnings converting the record set back:", warnings)
+			}
+			expected := test.readBack
+			if expected == nil {
+				expected = test.record
+			}
+			if len(records) != 1 || !reflect.DeepEqual(records[0], expected) {
+				t.Fatalf("expected %#v back, received %#v", expected, records)
+			}
+		})
+	}
+}
+
+func Test_RichRecords(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	ctx := context.Background()
+	records := make([]libdns.Record, 0, len(richRecordTests))
+	for _, test := range richRecordTests {
+		records = append(records, test.record)
+	}
+	if _, err := p.AppendRecords(ctx, testZone, records); err != nil {
+		t.Fatal("error appending records:", err)
+	}
+	if _, err := p.AppendRecords(ctx, testZone, records); err != nil {
+		t.Fatal("error appending the records again:", err)
+	}
+	for _, test := range richRecordTests {
+		rrs := server.RecordSet(testProject, "libdns", test.fqdn, test.record.RR().Type)
+		if rrs == nil || !containsValues(rrs.Rrdatas, []string{test.rrdata}) {
+			t.Fatalf("expected %s to hold %q, found %v", test.fqdn, test.rrdata, rrs)
+		}
+	}
+	if _, err := p.DeleteRecords(ctx, testZone, records); err != nil {
+		t.Fatal("error deleting records:", err)
+	}
+	for _, test := range richRecordTests {
+		if rrs := server.RecordSet(testProject, "libdns", test.fqdn, test.record.RR().Type); rrs != nil {
+			t.Fatalf("expected %s to be deleted, found %v", test.fqdn, rrs.Rrdatas)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package googleclouddns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// Record types supported by Cloud DNS that libdns has no type for. GetRecords returns them as the
+// types below, and they can be passed to AppendRecords, SetRecords and DeleteRecords like the libdns
+// types. HTTPS and SVCB records use libdns.ServiceBinding.
+
+// ALIAS is a Cloud DNS ALIAS record, which serves the A and AAAA records of the target at the zone
+// apex, where a CNAME cannot be used.
+type ALIAS struct {
+	Name   string
+	TTL    time.Duration
+	Target string
+}
+
+// RR returns the ALIAS record as a libdns.RR.
+func (a ALIAS) RR() libdns.RR {
+	return libdns.RR{
+		Name: a.Name,
+		TTL:  a.TTL,
+		Type: "ALIAS",
+		Data: a.Target,
+	}
+}
+
+// DS is a delegation signer record, set in the parent zone to delegate to a child zone signed with
+// DNSSEC. The digest is in hexadecimal.
+type DS struct {
+	Name       string
+	TTL        time.Duration
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     string
+}
+
+// RR returns the DS record as a libdns.RR.
+func (d DS) RR() libdns.RR {
+	data := fmt.Sprintf("%d %d %d %s", d.KeyTag, d.Algorithm, d.DigestType, d.Digest)
+	if d.KeyTag == 0 && d.Algorithm == 0 && d.DigestType == 0 && d.Digest == "" {
+		data = ""
+	}
+	return libdns.RR{
+		Name: d.Name,
+		TTL:  d.TTL,
+		Type: "DS",
+		Data: data,
+	}
+}
+
+// TLSA associates a TLS certificate or public key with a service for DANE. The name holds the port
+// and protocol of the service, e.g. "_443._tcp.www", and the certificate data is in hexadecimal.
+type TLSA struct {
+	Name         string
+	TTL          time.Duration
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Certificate  string
+}
+
+// RR returns the TLSA record as a libdns.RR.
+func (t TLSA) RR() libdns.RR {
+	data := fmt.Sprintf("%d %d %d %s", t.Usage, t.Selector, t.MatchingType, t.Certificate)
+	if t.Usage == 0 && t.Selector == 0 && t.MatchingType == 0 && t.Certificate == "" {
+		data = ""
+	}
+	return libdns.RR{
+		Name: t.Name,
+		TTL:  t.TTL,
+		Type: "TLSA",
+		Data: data,
+	}
+}
+
+// SSHFP publishes the fingerprint of an SSH host key. The fingerprint is in hexadecimal.
+type SSHFP struct {
+	Name            string
+	TTL             time.Duration
+	Algorithm       uint8
+	FingerprintType uint8
+	Fingerprint     string
+}
+
+// RR returns the SSHFP record as a libdns.RR.
+func (s SSHFP) RR() libdns.RR {
+	data := fmt.Sprintf("%d %d %s", s.Algorithm, s.FingerprintType, s.Fingerprint)
+	if s.Algorithm == 0 && s.FingerprintType == 0 && s.Fingerprint == "" {
+		data = ""
+	}
+	return libdns.RR{
+		Name: s.Name,
+		TTL:  s.TTL,
+		Type: "SSHFP",
+		Data: data,
+	}
+}
+
+// NAPTR is a naming authority pointer record, which rewrites a name into a URI or another name, e.g.
+// for SIP or ENUM. Either the regular expression or the replacement is used, the other being empty
+// or "." respectively.
+type NAPTR struct {
+	Name        string
+	TTL         time.Duration
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
+
+// RR returns the NAPTR record as a libdns.RR. The flags, service and regular expression are quoted
+// as character strings, e.g. `100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`.
+func (n NAPTR) RR() libdns.RR {
+	data := fmt.Sprintf("%d %d %s %s %s %s", n.Order, n.Preference, quoteCharacterString(n.Flags),
+		quoteCharacterString(n.Service), quoteCharacterString(n.Regexp), n.Replacement)
+	if n.Order == 0 && n.Preference == 0 && n.Flags == "" && n.Service == "" && n.Regexp == "" && n.Replacement == "" {
+		data = ""
+	}
+	return libdns.RR{
+		Name: n.Name,
+		TTL:  n.TTL,
+		Type: "NAPTR",
+		Data: data,
+	}
+}
+
+// parseDS parses the data of a DS record, i.e. "key-tag algorithm digest-type digest".
+func parseDS(rr libdns.RR) (DS, error) {
+	fields := strings.Fields(rr.Data)
+	if len(fields) < 4 {
+		return DS{}, fmt.Errorf("malformed DS value %q, expected 'key-tag algorithm digest-type digest'", rr.Data)
+	}
+	keyTag, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return DS{}, fmt.Errorf("invalid DS key tag %s: %w", fields[0], err)
+	}
+	numbers, err := parseUint8s(fields[1:3])
+	if err != nil {
+		return DS{}, fmt.Errorf("invalid DS value %q: %w", rr.Data, err)
+	}
+	return DS{
+		Name:       rr.Name,
+		TTL:        rr.TTL,
+		KeyTag:     uint16(keyTag),
+		Algorithm:  numbers[0],
+		DigestType: numbers[1],
+		Digest:     strings.Join(fields[3:], ""),
+	}, nil
+}
+
+// parseTLSA parses the data of a TLSA record, i.e. "usage selector matching-type certificate".
+func parseTLSA(rr libdns.RR) (TLSA, error) {
+	fields := strings.Fields(rr.Data)
+	if len(fields) < 4 {
+		return TLSA{}, fmt.Errorf("malformed TLSA value %q, expected 'usage selector matching-type certificate'", rr.Data)
+	}
+	numbers, err := parseUint8s(fields[:3])
+	if err != nil {
+		return TLSA{}, fmt.Errorf("invalid TLSA value %q: %w", rr.Data, err)
+	}
+	return TLSA{
+		Name:         rr.Name,
+		TTL:          rr.TTL,
+		Usage:        numbers[0],
+		Selector:     numbers[1],
+		MatchingType: numbers[2],
+		Certificate:  strings.Join(fields[3:], ""),
+	}, nil
+}
+
+// parseSSHFP parses the data of a SSHFP record, i.e. "algorithm fingerprint-type fingerprint".
+func parseSSHFP(rr libdns.RR) (SSHFP, error) {
+	fields := strings.Fields(rr.Data)
+	if len(fields) < 3 {
+		return SSHFP{}, fmt.Errorf("malformed SSHFP value %q, expected 'algorithm fingerprint-type fingerprint'", rr.Data)
+	}
+	numbers, err := parseUint8s(fields[:2])
+	if err != nil {
+		return SSHFP{}, fmt.Errorf("invalid SSHFP value %q: %w", rr.Data, err)
+	}
+	return SSHFP{
+		Name:            rr.Name,
+		TTL:             rr.TTL,
+		Algorithm:       numbers[0],
+		FingerprintType: numbers[1],
+		Fingerprint:     strings.Join(fields[2:], ""),
+	}, nil
+}
+
+// parseNAPTR parses the data of a NAPTR record, i.e. `order preference "flags" "service" "regexp"
+// replacement`.
+func parseNAPTR(rr libdns.RR) (NAPTR, error) {
+	fields, err := splitTXT(rr.Data)
+	if err != nil {
+		return NAPTR{}, err
+	}
+	if len(fields) != 6 {
+		return NAPTR{}, fmt.Errorf(`malformed NAPTR value %q, expected 'order preference "flags" "service" "regexp" replacement'`, rr.Data)
+	}
+	order, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return NAPTR{}, fmt.Errorf("invalid NAPTR order %s: %w", fields[0], err)
+	}
+	preference, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return NAPTR{}, fmt.Errorf("invalid NAPTR preference %s: %w", fields[1], err)
+	}
+	return NAPTR{
+		Name:        rr.Name,
+		TTL:         rr.TTL,
+		Order:       uint16(order),
+		Preference:  uint16(preference),
+		Flags:       fields[2],
+		Service:     fields[3],
+		Regexp:      fields[4],
+		Replacement: fields[5],
+	}, nil
+}
+
+// parseUint8s parses the numbers of a record.
+func parseUint8s(fields []string) ([]uint8, error) {
+	numbers := make([]uint8, 0, len(fields))
+	for _, field := range fields {
+		number, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, uint8(number))
+	}
+	return numbers, nil
+}
+
+// Interface guards
+var (
+	_ libdns.Record = ALIAS{}
+	_ libdns.Record = DS{}
+	_ libdns.Record = TLSA{}
+	_ libdns.Record = SSHFP{}
+	_ libdns.Record = NAPTR{}
+)
@@ -0,0 +1,133 @@
+package googleclouddns
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// defaultMaxAttempts is the number of attempts for a call to Cloud DNS if MaxAttempts is not set
+	defaultMaxAttempts = 5
+	// defaultMaxRetryTime limits the time spent retrying a call to Cloud DNS if MaxRetryTime is not set
+	defaultMaxRetryTime = time.Minute
+)
+
+// Backoff between attempts, the delay doubles after each attempt up to the maximum delay.
+var (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 16 * time.Second
+)
+
+// retryBudget limits the retries of all the Cloud DNS calls made for a single call to the provider,
+// so the retries of calls nested in a retried change share the limits instead of multiplying them.
+type retryBudget struct {
+	mutex    sync.Mutex
+	retries  int
+	deadline time.Time
+}
+
+// retryBudgetKey is the context key of the retry budget of the current call to the provider.
+type retryBudgetKey struct{}
+
+// withRetryBudget returns a context holding a new retry budget for the Cloud DNS calls made with it,
+// unless ctx already holds one.
+func (p *Provider) withRetryBudget(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(retryBudgetKey{}).(*retryBudget); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, retryBudgetKey{}, p.newRetryBudget())
+}
+
+// newRetryBudget returns a budget of MaxAttempts-1 retries to be made within MaxRetryTime.
+func (p *Provider) newRetryBudget() *retryBudget {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	maxRetryTime := p.MaxRetryTime
+	if maxRetryTime <= 0 {
+		maxRetryTime = defaultMaxRetryTime
+	}
+	return &retryBudget{retries: maxAttempts - 1, deadline: time.Now().Add(maxRetryTime)}
+}
+
+// spend takes a retry after the delay from the budget, and returns false without taking it if no
+// retries are left or the delay ends after the deadline.
+func (b *retryBudget) spend(delay time.Duration) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.retries <= 0 || time.Now().Add(delay).After(b.deadline) {
+		return false
+	}
+	b.retries--
+	return true
+}
+
+// retry calls fn until it succeeds, fails with an error that cannot be retried, or the retry budget of
+// ctx is spent, in which case the last error is returned. Without a budget in ctx, the call gets its
+// own. Attempts are separated by a jittered exponential backoff, or the delay requested by Google,
+// and stop as soon as ctx is done.
+func retry[T any](ctx context.Context, p *Provider, retryable func(error) bool, fn func() (T, error)) (T, error) {
+	budget, ok := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	if !ok {
+		budget = p.newRetryBudget()
+	}
+	for attempt := 1; ; attempt++ {
+		result, err := fn()
+		if err == nil || !retryable(err) {
+			return result, err
+		}
+		delay := retryDelay(attempt, err)
+		if !budget.spend(delay) {
+			return result, err
+		}
+		p.logger().Debug("retrying Cloud DNS call", "attempt", attempt, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns a random delay up to the exponential backoff for the attempt, or the delay
+// requested by Google in the Retry-After header if it is longer.
+func retryDelay(attempt int, err error) time.Duration {
+	backoff := retryMaxDelay
+	if attempt < 32 && retryBaseDelay<<(attempt-1) < retryMaxDelay {
+		backoff = retryBaseDelay << (attempt - 1)
+	}
+	delay := time.Duration(rand.Int64N(int64(backoff)) + 1)
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) && gErr.Header != nil {
+		if seconds, err := strconv.Atoi(gErr.Header.Get("Retry-After")); err == nil && time.Duration(seconds)*time.Second > delay {
+			delay = time.Duration(seconds) * time.Second
+		}
+	}
+	return delay
+}
+
+// isTransientError returns true for the errors worth retrying the same call for: rate limits and
+// server errors.
+func isTransientError(err error) bool {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) {
+		return false
+	}
+	return gErr.Code == http.StatusTooManyRequests || gErr.Code >= http.StatusInternalServerError
+}
+
+// isConflictError returns true if a change conflicts with the current state of the managed zone, in
+// which case the state has to be read again before the change is reapplied.
+func isConflictError(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
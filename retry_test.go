@@ -0,0 +1,184 @@
+package googleclouddns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libdns/googleclouddns/googleclouddnstest"
+	"github.com/libdns/libdns"
+	"google.golang.org/api/googleapi"
+)
+
+// setRetryDelays shortens the backoff between attempts for the duration of the test.
+func setRetryDelays(t *testing.T, base, max time.Duration) {
+	baseDelay, maxDelay := retryBaseDelay, retryMaxDelay
+	retryBaseDelay, retryMaxDelay = base, max
+	t.Cleanup(func() {
+		retryBaseDelay, retryMaxDelay = baseDelay, maxDelay
+	})
+}
+
+// changesTransport counts the changes submitted to the server.
+type changesTransport struct {
+	base  http.RoundTripper
+	posts atomic.Int32
+}
+
+func (c *changesTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/changes") {
+		c.posts.Add(1)
+	}
+	return c.base.RoundTrip(r)
+}
+
+func Test_Retry(t *testing.T) {
+	setRetryDelays(t, time.Millisecond, 5*time.Millisecond)
+	challenge := []libdns.Record{libdns.TXT{Name: "_acme-challenge", Text: "challenge", TTL: time.Minute}}
+	t.Run("rate limited changes are retried", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		server.InjectFault(googleclouddnstest.Fault{Method: http.MethodPost, Resource: "changes", Code: http.StatusTooManyRequests, Count: 2})
+		records, err := p.AppendRecords(context.Background(), testZone, challenge)
+		if err != nil {
+			t.Fatal("error appending records:", err)
+		}
+		if len(records) != 1 || len(server.Changes(testProject, "libdns")) != 1 {
+			t.Fatal("expected the record to be appended once")
+		}
+	})
+	t.Run("server errors are retried", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		server.InjectFault(googleclouddnstest.Fault{Resource: "rrsets", Code: http.StatusServiceUnavailable, Count: 2})
+		if _, err := p.GetRecords(context.Background(), testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+	})
+	t.Run("changes applied despite a server error are not submitted again", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		ctx := context.Background()
+		server.InjectFault(googleclouddnstest.Fault{Method: http.MethodPost, Resource: "changes", Code: http.StatusServiceUnavailable, Count: 1, Applied: true})
+		records, err := p.AppendRecords(ctx, testZone, challenge)
+		if err != nil {
+			t.Fatal("error appending records:", err)
+		}
+		compareTestData(challenge, records, t)
+		if len(records) != 1 || len(server.Changes(testProject, "libdns")) != 1 {
+			t.Fatalf("expected the record to be appended once, received %v", records)
+		}
+		server.InjectFault(googleclouddnstest.Fault{Method: http.MethodPost, Resource: "changes", Code: http.StatusServiceUnavailable, Count: 1, Applied: true})
+		records, err = p.DeleteRecords(ctx, testZone, challenge)
+		if err != nil {
+			t.Fatal("error deleting records:", err)
+		}
+		compareTestData(challenge, records, t)
+		if len(records) != 1 || len(server.Changes(testProject, "libdns")) != 2 {
+			t.Fatalf("expected the record to be deleted once, received %v", records)
+		}
+	})
+	t.Run("conflicting changes are reapplied", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		server.InjectFault(googleclouddnstest.Fault{Method: http.MethodPost, Resource: "changes", Code: http.StatusPreconditionFailed, Count: 1})
+		records, err := p.AppendRecords(context.Background(), testZone, challenge)
+		if err != nil {
+			t.Fatal("error appending records:", err)
+		}
+		if len(records) != 1 || server.RecordSet(testProject, "libdns", "_acme-challenge.libdns.io.", "TXT") == nil {
+			t.Fatal("expected the record to be appended")
+		}
+	})
+	t.Run("changes deleting a missing record set are reapplied", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		if _, err := p.AppendRecords(context.Background(), testZone, challenge); err != nil {
+			t.Fatal("error appending records:", err)
+		}
+		server.InjectFault(googleclouddnstest.Fault{Method: http.MethodPost, Resource: "changes", Code: http.StatusNotFound, Count: 1})
+		records, err := p.DeleteRecords(context.Background(), testZone, challenge)
+		if err != nil {
+			t.Fatal("error deleting records:", err)
+		}
+		if len(records) != 1 || server.RecordSet(testProject, "libdns", "_acme-challenge.libdns.io.", "TXT") != nil {
+			t.Fatal("expected the record to be deleted")
+		}
+	})
+	t.Run("other errors are not retried", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		server.InjectFault(googleclouddnstest.Fault{Code: http.StatusBadRequest, Count: 1})
+		if _, err := p.GetRecords(context.Background(), testZone); err == nil {
+			t.Fatal("expected an error back but did not receive one")
+		}
+	})
+	t.Run("attempts are limited", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		p.MaxAttempts = 2
+		server.InjectFault(googleclouddnstest.Fault{Resource: "rrsets", Code: http.StatusTooManyRequests, Count: 2})
+		_, err := p.GetRecords(context.Background(), testZone)
+		var gErr *googleapi.Error
+		if !errors.As(err, &gErr) || gErr.Code != http.StatusTooManyRequests {
+			t.Fatal("expected a rate limit error back, received", err)
+		}
+		if _, err := p.GetRecords(context.Background(), testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+	})
+	t.Run("attempts are shared by the reapplied changes", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		p.MaxAttempts = 3
+		transport := &changesTransport{base: server.Client().Transport}
+		p.HTTPClient = &http.Client{Transport: transport}
+		for range 5 {
+			server.InjectFault(googleclouddnstest.Fault{Method: http.MethodPost, Resource: "changes", Code: http.StatusServiceUnavailable, Count: 1})
+			server.InjectFault(googleclouddnstest.Fault{Method: http.MethodPost, Resource: "changes", Code: http.StatusConflict, Count: 1})
+		}
+		if _, err := p.AppendRecords(context.Background(), testZone, challenge); err == nil {
+			t.Fatal("expected an error back but did not receive one")
+		}
+		if posts := transport.posts.Load(); posts != 3 {
+			t.Fatal("expected the change to be submitted three times, submitted", posts)
+		}
+	})
+	t.Run("retry time is limited", func(t *testing.T) {
+		setRetryDelays(t, time.Second, time.Second)
+		p, server := getFakeDNSClient(t)
+		p.MaxRetryTime = 10 * time.Millisecond
+		server.InjectFault(googleclouddnstest.Fault{Code: http.StatusServiceUnavailable, RetryAfter: time.Second})
+		start := time.Now()
+		if _, err := p.GetRecords(context.Background(), testZone); err == nil {
+			t.Fatal("expected an error back but did not receive one")
+		}
+		if time.Since(start) > 500*time.Millisecond {
+			t.Fatal("retried for longer than the retry time")
+		}
+	})
+	t.Run("retries stop with the context", func(t *testing.T) {
+		setRetryDelays(t, time.Second, time.Second)
+		p, server := getFakeDNSClient(t)
+		if _, err := p.GetRecords(context.Background(), testZone); err != nil { // load the zone map
+			t.Fatal("error listing records:", err)
+		}
+		server.InjectFault(googleclouddnstest.Fault{Code: http.StatusServiceUnavailable})
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := p.AppendRecords(ctx, testZone, challenge)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expected the context error back, received", err)
+		}
+	})
+}
+
+func Test_RetryDelay(t *testing.T) {
+	setRetryDelays(t, 100*time.Millisecond, time.Second)
+	for attempt := 1; attempt <= 40; attempt++ {
+		delay := retryDelay(attempt, errors.New("failure"))
+		if delay <= 0 || delay > time.Second || (attempt == 1 && delay > 100*time.Millisecond) {
+			t.Fatalf("unexpected delay %v for attempt %d", delay, attempt)
+		}
+	}
+	err := &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"3"}}}
+	if delay := retryDelay(1, err); delay != 3*time.Second {
+		t.Fatal("expected the delay requested by Google, received", delay)
+	}
+}
@@ -0,0 +1,535 @@
+package googleclouddns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"google.golang.org/api/dns/v1"
+)
+
+// RoutingPolicy is a libdns.Record for a Cloud DNS record set whose values are served through a
+// routing policy, e.g. geolocation or weighted round robin, instead of plain record data.
+//
+// GetRecords returns one RoutingPolicy per record set with a routing policy. Appending a RoutingPolicy
+// merges its items into the existing policy, and deleting one removes only its items (or values) from
+// the existing policy, so policies are never wiped by accident.
+type RoutingPolicy struct {
+	Name   string
+	Type   string
+	TTL    time.Duration
+	Policy *dns.RRSetRoutingPolicy
+}
+
+// RR returns the RoutingPolicy as a libdns.RR. The data is a readable summary of the policy, e.g.
+// "geo us-east1=10.0.0.1,10.0.0.2 europe-west1=10.0.0.3".
+func (r RoutingPolicy) RR() libdns.RR {
+	return libdns.RR{
+		Name: r.Name,
+		TTL:  r.TTL,
+		Type: r.Type,
+		Data: formatRoutingPolicy(r.Policy),
+	}
+}
+
+// SetGeoRecords creates or replaces the record set for the name and type with a geolocation routing
+// policy. Each Google Cloud region, e.g. "us-east1", maps to the values served to the clients closest
+// to it. The name can either be relative to the zone or fully qualified. It returns the record set
+// as it was stored.
+func (p *Provider) SetGeoRecords(ctx context.Context, zone, name, recordType string, ttl time.Duration, locations map[string][]string) (RoutingPolicy, error) {
+	if len(locations) == 0 {
+		return RoutingPolicy{}, fmt.Errorf("no locations set for the geolocation routing policy of %s (%s)", name, recordType)
+	}
+	policy := &dns.RRSetRoutingPolicy{Geo: &dns.RRSetRoutingPolicyGeoPolicy{}}
+	for _, location := range slices.Sorted(maps.Keys(locations)) {
+		policy.Geo.Items = append(policy.Geo.Items, &dns.RRSetRoutingPolicyGeoPolicyGeoPolicyItem{
+			Location: location,
+			Rrdatas:  prepRoutingValuesForCloudDNS(recordType, locations[location]),
+		})
+	}
+	return p.setRoutingPolicy(ctx, zone, name, recordType, ttl, policy)
+}
+
+// SetWeightedRecords creates or replaces the record set for the name and type with a weighted round
+// robin routing policy. Each weight maps to the values served for that share of the queries, the
+// weights being relative to each other. The name can either be relative to the zone or fully
+// qualified. It returns the record set as it was stored.
+func (p *Provider) SetWeightedRecords(ctx context.Context, zone, name, recordType string, ttl time.Duration, weights map[float64][]string) (RoutingPolicy, error) {
+	if len(weights) == 0 {
+		return RoutingPolicy{}, fmt.Errorf("no weights set for the weighted round robin routing policy of %s (%s)", name, recordType)
+	}
+	policy := &dns.RRSetRoutingPolicy{Wrr: &dns.RRSetRoutingPolicyWrrPolicy{}}
+	for _, weight := range slices.Sorted(maps.Keys(weights)) {
+		if weight < 0 {
+			return RoutingPolicy{}, fmt.Errorf("invalid weight %v for %s (%s), weights cannot be negative", weight, name, recordType)
+		}
+		policy.Wrr.Items = append(policy.Wrr.Items, &dns.RRSetRoutingPolicyWrrPolicyWrrPolicyItem{
+			Weight:  weight,
+			Rrdatas: prepRoutingValuesForCloudDNS(recordType, weights[weight]),
+		})
+	}
+	return p.setRoutingPolicy(ctx, zone, name, recordType, ttl, policy)
+}
+
+// Failover describes a primary/backup routing policy. Queries are answered with the primary targets
+// while they are healthy, and with the backup geolocation policy otherwise.
+type Failover struct {
+	// Primary holds the health checked targets serving the queries, e.g. internal load balancers.
+	Primary *dns.RRSetRoutingPolicyHealthCheckTargets
+	// Backup maps Google Cloud regions to the values served when the primary targets are unhealthy.
+	Backup map[string][]string
+	// BackupTargets maps Google Cloud regions to the health checked targets served when the primary
+	// targets are unhealthy. A region can hold both values and targets.
+	BackupTargets map[string]*dns.RRSetRoutingPolicyHealthCheckTargets
+	// BackupFencing stops the backup from failing over to other regions when its targets are unhealthy.
+	BackupFencing bool
+	// TrickleRatio is the share of the queries answered with the backup even when the primary
+	// targets are healthy, between 0 and 1.
+	TrickleRatio float64
+	// HealthCheck is the health check used for external endpoints, e.g.
+	// "projects/my-project/global/healthChecks/my-check".
+	HealthCheck string
+}
+
+// Failover returns the primary/backup routing policy of the record, if it has one.
+func (r RoutingPolicy) Failover() (Failover, bool) {
+	if r.Policy == nil || r.Policy.PrimaryBackup == nil {
+		return Failover{}, false
+	}
+	policy := cloneRoutingPolicy(r.Policy)
+	failover := Failover{
+		Primary:      policy.PrimaryBackup.PrimaryTargets,
+		TrickleRatio: policy.PrimaryBackup.TrickleTraffic,
+		HealthCheck:  policy.HealthCheck,
+	}
+	if backup := policy.PrimaryBackup.BackupGeoTargets; backup != nil {
+		failover.BackupFencing = backup.EnableFencing
+		for _, item := range backup.Items {
+			if len(item.Rrdatas) > 0 {
+				if failover.Backup == nil {
+					failover.Backup = make(map[string][]string)
+				}
+				failover.Backup[item.Location] = item.Rrdatas
+			}
+			if item.HealthCheckedTargets != nil {
+				if failover.BackupTargets == nil {
+					failover.BackupTargets = make(map[string]*dns.RRSetRoutingPolicyHealthCheckTargets)
+				}
+				failover.BackupTargets[item.Location] = item.HealthCheckedTargets
+			}
+		}
+	}
+	return failover, true
+}
+
+// SetFailoverRecords creates or replaces the record set for the name and type with a primary/backup
+// routing policy. The name can either be relative to the zone or fully qualified. It returns the
+// record set as it was stored.
+func (p *Provider) SetFailoverRecords(ctx context.Context, zone, name, recordType string, ttl time.Duration, failover Failover) (RoutingPolicy, error) {
+	if failover.Primary == nil || (len(failover.Primary.InternalLoadBalancers) == 0 && len(failover.Primary.ExternalEndpoints) == 0) {
+		return RoutingPolicy{}, fmt.Errorf("no primary targets set for the failover routing policy of %s (%s)", name, recordType)
+	}
+	if len(failover.Backup) == 0 && len(failover.BackupTargets) == 0 {
+		return RoutingPolicy{}, fmt.Errorf("no backup set for the failover routing policy of %s (%s)", name, recordType)
+	}
+	if failover.TrickleRatio < 0 || failover.TrickleRatio > 1 {
+		return RoutingPolicy{}, fmt.Errorf("invalid trickle ratio %v for %s (%s), it must be between 0 and 1", failover.TrickleRatio, name, recordType)
+	}
+	backup := &dns.RRSetRoutingPolicyGeoPolicy{EnableFencing: failover.BackupFencing}
+	locations := slices.Sorted(maps.Keys(failover.Backup))
+	for location := range failover.BackupTargets {
+		if _, ok := failover.Backup[location]; !ok {
+			locations = append(locations, location)
+		}
+	}
+	slices.Sort(locations)
+	for _, location := range locations {
+		item := &dns.RRSetRoutingPolicyGeoPolicyGeoPolicyItem{
+			Location:             location,
+			HealthCheckedTargets: failover.BackupTargets[location],
+		}
+		if values, ok := failover.Backup[location]; ok {
+			item.Rrdatas = prepRoutingValuesForCloudDNS(recordType, values)
+		}
+		backup.Items = append(backup.Items, item)
+	}
+	policy := &dns.RRSetRoutingPolicy{
+		PrimaryBackup: &dns.RRSetRoutingPolicyPrimaryBackupPolicy{
+			PrimaryTargets:   failover.Primary,
+			BackupGeoTargets: backup,
+			TrickleTraffic:   failover.TrickleRatio,
+		},
+		HealthCheck: failover.HealthCheck,
+	}
+	return p.setRoutingPolicy(ctx, zone, name, recordType, ttl, policy)
+}
+
+// setRoutingPolicy sets the record set for the name and type to the routing policy and returns the
+// resulting RoutingPolicy record.
+func (p *Provider) setRoutingPolicy(ctx context.Context, zone, name, recordType string, ttl time.Duration, policy *dns.RRSetRoutingPolicy) (RoutingPolicy, error) {
+	record := RoutingPolicy{
+		Name:   libdns.RelativeName(libdns.AbsoluteName(name, zone), zone),
+		Type:   recordType,
+		TTL:    ttl,
+		Policy: policy,
+	}
+	records, err := p.SetRecords(ctx, zone, []libdns.Record{record})
+	if err != nil {
+		return RoutingPolicy{}, err
+	}
+	for _, r := range records {
+		if routingPolicy, ok := r.(RoutingPolicy); ok && routingPolicy.Name == record.Name && routingPolicy.Type == record.Type {
+			return routingPolicy, nil
+		}
+	}
+	return record, nil
+}
+
+// prepRoutingValuesForCloudDNS encodes the values of a routing policy item the same way plain values are.
+func prepRoutingValuesForCloudDNS(recordType string, values []string) []string {
+	prepped := make([]string, 0, len(values))
+	for _, value := range values {
+		prepped = append(prepped, encodeRdata(recordType, value))
+	}
+	return prepped
+}
+
+// formatRoutingPolicy returns a readable summary of the routing policy.
+func formatRoutingPolicy(policy *dns.RRSetRoutingPolicy) string {
+	if policy == nil {
+		return ""
+	}
+	parts := make([]string, 0)
+	if policy.Geo != nil {
+		parts = append(parts, formatGeoPolicy(policy.Geo))
+	}
+	if policy.Wrr != nil {
+		part := "wrr"
+		for _, item := range policy.Wrr.Items {
+			part += fmt.Sprintf(" %s=%s", strconv.FormatFloat(item.Weight, 'g', -1, 64), strings.Join(item.Rrdatas, ","))
+		}
+		parts = append(parts, part)
+	}
+	if policy.PrimaryBackup != nil {
+		part := "primaryBackup primary=" + formatHealthCheckTargets(policy.PrimaryBackup.PrimaryTargets)
+		if policy.PrimaryBackup.BackupGeoTargets != nil {
+			part += "; backup " + formatGeoPolicy(policy.PrimaryBackup.BackupGeoTargets)
+		}
+		if policy.PrimaryBackup.TrickleTraffic != 0 {
+			part += "; trickle " + strconv.FormatFloat(policy.PrimaryBackup.TrickleTraffic, 'g', -1, 64)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// formatHealthCheckTargets returns the load balancers as ip:port, followed by the external endpoints.
+func formatHealthCheckTargets(targets *dns.RRSetRoutingPolicyHealthCheckTargets) string {
+	if targets == nil {
+		return ""
+	}
+	values := make([]string, 0)
+	for _, target := range targets.InternalLoadBalancers {
+		values = append(values, net.JoinHostPort(target.IpAddress, target.Port))
+	}
+	return strings.Join(append(values, targets.ExternalEndpoints...), ",")
+}
+
+// formatGeoPolicy returns the geolocation policy as "geo", followed by "fencing" if it is enabled and
+// by the values and health checked targets of each location.
+func formatGeoPolicy(geo *dns.RRSetRoutingPolicyGeoPolicy) string {
+	formatted := "geo"
+	if geo.EnableFencing {
+		formatted += " fencing"
+	}
+	for _, item := range geo.Items {
+		values := item.Rrdatas
+		if item.HealthCheckedTargets != nil {
+			values = append(slices.Clone(values), formatHealthCheckTargets(item.HealthCheckedTargets))
+		}
+		formatted += fmt.Sprintf(" %s=%s", item.Location, strings.Join(values, ","))
+	}
+	return formatted
+}
+
+// isRoutingPolicy returns true if the record is a RoutingPolicy.
+func isRoutingPolicy(record libdns.Record) bool {
+	_, ok := record.(RoutingPolicy)
+	return ok
+}
+
+// routingPolicy returns the routing policy of this set of records, merging the policies of all the
+// RoutingPolicy records in order. It returns nil if there are none, and an error if routing policies
+// are mixed with plain records since Cloud DNS does not support both in one record set.
+func (l libdnsRecords) routingPolicy() (*dns.RRSetRoutingPolicy, error) {
+	var policy *dns.RRSetRoutingPolicy
+	plainRecords := 0
+	for _, record := range l {
+		routingPolicy, ok := record.(RoutingPolicy)
+		if !ok {
+			plainRecords++
+			continue
+		}
+		policy = mergeRoutingPolicies(policy, routingPolicy.Policy)
+	}
+	if policy != nil && plainRecords > 0 {
+		rr := l[0].RR()
+		return nil, fmt.Errorf("record set %s (%s) cannot hold both a routing policy and plain values", rr.Name, rr.Type)
+	}
+	return policy, nil
+}
+
+// mergeRoutingPolicies returns a copy of the existing policy with the added policy merged in. Items
+// for the same location or weight have their values combined, other items are added.
+func mergeRoutingPolicies(existing, added *dns.RRSetRoutingPolicy) *dns.RRSetRoutingPolicy {
+	if existing == nil {
+		return cloneRoutingPolicy(added)
+	}
+	merged := cloneRoutingPolicy(existing)
+	if added == nil {
+		return merged
+	}
+	added = cloneRoutingPolicy(added)
+	if added.Geo != nil {
+		merged.Geo = mergeGeoPolicies(merged.Geo, added.Geo)
+	}
+	if added.Wrr != nil {
+		if merged.Wrr == nil {
+			merged.Wrr = &dns.RRSetRoutingPolicyWrrPolicy{}
+		}
+		for _, item := range added.Wrr.Items {
+			if i := slices.IndexFunc(merged.Wrr.Items, func(m *dns.RRSetRoutingPolicyWrrPolicyWrrPolicyItem) bool {
+				return m.Weight == item.Weight
+			}); i >= 0 {
+				merged.Wrr.Items[i].Rrdatas = unionValues(merged.Wrr.Items[i].Rrdatas, item.Rrdatas)
+				if item.HealthCheckedTargets != nil {
+					merged.Wrr.Items[i].HealthCheckedTargets = item.HealthCheckedTargets
+				}
+				continue
+			}
+			merged.Wrr.Items = append(merged.Wrr.Items, item)
+		}
+	}
+	if added.PrimaryBackup != nil {
+		merged.PrimaryBackup = added.PrimaryBackup
+	}
+	if added.HealthCheck != "" {
+		merged.HealthCheck = added.HealthCheck
+	}
+	return merged
+}
+
+// mergeGeoPolicies merges the added geolocation policy into the existing one, which is modified and
+// returned. Items for the same location have their values combined, other items are added.
+func mergeGeoPolicies(existing, added *dns.RRSetRoutingPolicyGeoPolicy) *dns.RRSetRoutingPolicyGeoPolicy {
+	if existing == nil {
+		return added
+	}
+	existing.EnableFencing = existing.EnableFencing || added.EnableFencing
+	for _, item := range added.Items {
+		if i := slices.IndexFunc(existing.Items, func(m *dns.RRSetRoutingPolicyGeoPolicyGeoPolicyItem) bool {
+			return m.Location == item.Location
+		}); i >= 0 {
+			existing.Items[i].Rrdatas = unionValues(existing.Items[i].Rrdatas, item.Rrdatas)
+			if item.HealthCheckedTargets != nil {
+				existing.Items[i].HealthCheckedTargets = item.HealthCheckedTargets
+			}
+			continue
+		}
+		existing.Items = append(existing.Items, item)
+	}
+	return existing
+}
+
+// containsRoutingPolicy returns true if every item and value of the policy is already part of the
+// existing policy.
+func containsRoutingPolicy(existing, policy *dns.RRSetRoutingPolicy) bool {
+	if existing == nil || policy == nil {
+		return existing == policy
+	}
+	if policy.Geo != nil {
+		if existing.Geo == nil {
+			return false
+		}
+		for _, item := range policy.Geo.Items {
+			i := slices.IndexFunc(existing.Geo.Items, func(m *dns.RRSetRoutingPolicyGeoPolicyGeoPolicyItem) bool {
+				return m.Location == item.Location
+			})
+			if i < 0 || !containsValues(existing.Geo.Items[i].Rrdatas, item.Rrdatas) ||
+				(item.HealthCheckedTargets != nil && !sameJSON(existing.Geo.Items[i].HealthCheckedTargets, item.HealthCheckedTargets)) {
+				return false
+			}
+		}
+	}
+	if policy.Wrr != nil {
+		if existing.Wrr == nil {
+			return false
+		}
+		for _, item := range policy.Wrr.Items {
+			i := slices.IndexFunc(existing.Wrr.Items, func(m *dns.RRSetRoutingPolicyWrrPolicyWrrPolicyItem) bool {
+				return m.Weight == item.Weight
+			})
+			if i < 0 || !containsValues(existing.Wrr.Items[i].Rrdatas, item.Rrdatas) ||
+				(item.HealthCheckedTargets != nil && !sameJSON(existing.Wrr.Items[i].HealthCheckedTargets, item.HealthCheckedTargets)) {
+				return false
+			}
+		}
+	}
+	if policy.PrimaryBackup != nil && !sameJSON(existing.PrimaryBackup, policy.PrimaryBackup) {
+		return false
+	}
+	return policy.HealthCheck == "" || policy.HealthCheck == existing.HealthCheck
+}
+
+// removeRoutingPolicy returns a copy of the existing policy without the items of the removed policy.
+// Items listing values only lose those values, items without values are removed entirely. It returns
+// nil if nothing is left of the existing policy.
+func removeRoutingPolicy(existing, removed *dns.RRSetRoutingPolicy) *dns.RRSetRoutingPolicy {
+	remaining := cloneRoutingPolicy(existing)
+	if remaining == nil || removed == nil {
+		return remaining
+	}
+	if removed.Geo != nil && remaining.Geo != nil {
+		items := make([]*dns.RRSetRoutingPolicyGeoPolicyGeoPolicyItem, 0)
+		for _, item := range remaining.Geo.Items {
+			i := slices.IndexFunc(removed.Geo.Items, func(r *dns.RRSetRoutingPolicyGeoPolicyGeoPolicyItem) bool {
+				return r.Location == item.Location
+			})
+			if i >= 0 {
+				if len(removed.Geo.Items[i].Rrdatas) == 0 {
+					continue
+				}
+				item.Rrdatas = subtractValues(item.Rrdatas, removed.Geo.Items[i].Rrdatas)
+				if len(item.Rrdatas) == 0 && item.HealthCheckedTargets == nil {
+					continue
+				}
+			}
+			items = append(items, item)
+		}
+		remaining.Geo.Items = items
+		if len(items) == 0 {
+			remaining.Geo = nil
+		}
+	}
+	if removed.Wrr != nil && remaining.Wrr != nil {
+		items := make([]*dns.RRSetRoutingPolicyWrrPolicyWrrPolicyItem, 0)
+		for _, item := range remaining.Wrr.Items {
+			i := slices.IndexFunc(removed.Wrr.Items, func(r *dns.RRSetRoutingPolicyWrrPolicyWrrPolicyItem) bool {
+				return r.Weight == item.Weight
+			})
+			if i >= 0 {
+				if len(removed.Wrr.Items[i].Rrdatas) == 0 {
+					continue
+				}
+				item.Rrdatas = subtractValues(item.Rrdatas, removed.Wrr.Items[i].Rrdatas)
+				if len(item.Rrdatas) == 0 && item.HealthCheckedTargets == nil {
+					continue
+				}
+			}
+			items = append(items, item)
+		}
+		remaining.Wrr.Items = items
+		if len(items) == 0 {
+			remaining.Wrr = nil
+		}
+	}
+	if removed.PrimaryBackup != nil {
+		remaining.PrimaryBackup = nil
+	}
+	if remaining.Geo == nil && remaining.Wrr == nil && remaining.PrimaryBackup == nil {
+		return nil
+	}
+	return remaining
+}
+
+// cloneRoutingPolicy returns a deep copy of the routing policy without the API kinds, so policies
+// read from Cloud DNS compare equal to the ones built locally.
+func cloneRoutingPolicy(policy *dns.RRSetRoutingPolicy) *dns.RRSetRoutingPolicy {
+	if policy == nil {
+		return nil
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return policy
+	}
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return policy
+	}
+	data, err = json.Marshal(removeKinds(raw))
+	if err != nil {
+		return policy
+	}
+	clone := &dns.RRSetRoutingPolicy{}
+	if err := json.Unmarshal(data, clone); err != nil {
+		return policy
+	}
+	if clone.Wrr != nil {
+		for _, item := range clone.Wrr.Items { // a weight of 0 is valid and must be sent
+			item.ForceSendFields = []string{"Weight"}
+		}
+	}
+	return clone
+}
+
+// removeKinds removes the "kind" fields from the decoded JSON value, recursively.
+func removeKinds(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		delete(v, "kind")
+		for key, child := range v {
+			v[key] = removeKinds(child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = removeKinds(child)
+		}
+	}
+	return value
+}
+
+// sameJSON returns true if both values encode to the same JSON.
+func sameJSON(a, b any) bool {
+	aData, aErr := json.Marshal(a)
+	bData, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && string(aData) == string(bData)
+}
+
+// containsValues returns true if every value of the subset is one of the values.
+func containsValues(values, subset []string) bool {
+	for _, value := range subset {
+		if !slices.Contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// unionValues returns the values followed by the added values they do not hold yet.
+func unionValues(values, added []string) []string {
+	union := slices.Clone(values)
+	for _, value := range added {
+		if !slices.Contains(union, value) {
+			union = append(union, value)
+		}
+	}
+	return union
+}
+
+// subtractValues returns the values that are not removed, in the same order.
+func subtractValues(values, removed []string) []string {
+	remaining := make([]string, 0, len(values))
+	for _, value := range values {
+		if !slices.Contains(removed, value) {
+			remaining = append(remaining, value)
+		}
+	}
+	return remaining
+}
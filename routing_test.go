@@ -0,0 +1,336 @@
+package googleclouddns
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"google.golang.org/api/dns/v1"
+)
+
+func geoPolicy(items map[string][]string) *dns.RRSetRoutingPolicy {
+	policy := &dns.RRSetRoutingPolicy{Geo: &dns.RRSetRoutingPolicyGeoPolicy{}}
+	for _, location := range []string{"us-east1", "europe-west1", "asia-east1"} {
+		if values, ok := items[location]; ok {
+			policy.Geo.Items = append(policy.Geo.Items, &dns.RRSetRoutingPolicyGeoPolicyGeoPolicyItem{
+				Location: location,
+				Rrdatas:  values,
+			})
+		}
+	}
+	return policy
+}
+
+func Test_RoutingPolicyMerging(t *testing.T) {
+	existing := geoPolicy(map[string][]string{"us-east1": {"10.0.0.1"}})
+	t.Run("merging adds values and items", func(t *testing.T) {
+		merged := mergeRoutingPolicies(existing, geoPolicy(map[string][]string{
+			"us-east1":     {"10.0.0.2"},
+			"europe-west1": {"10.0.0.3"},
+		}))
+		expected := geoPolicy(map[string][]string{"us-east1": {"10.0.0.1", "10.0.0.2"}, "europe-west1": {"10.0.0.3"}})
+		if !sameJSON(merged, expected) {
+			t.Fatalf("expected policy %s, received %s", formatRoutingPolicy(expected), formatRoutingPolicy(merged))
+		}
+		if len(existing.Geo.Items[0].Rrdatas) != 1 {
+			t.Fatal("merging modified the existing policy")
+		}
+	})
+	t.Run("containment checks items and values", func(t *testing.T) {
+		merged := geoPolicy(map[string][]string{"us-east1": {"10.0.0.1", "10.0.0.2"}, "europe-west1": {"10.0.0.3"}})
+		if !containsRoutingPolicy(merged, existing) {
+			t.Fatal("expected the merged policy to contain the existing one")
+		}
+		if containsRoutingPolicy(existing, merged) {
+			t.Fatal("expected the existing policy not to contain the merged one")
+		}
+	})
+	t.Run("removing values keeps the rest of the policy", func(t *testing.T) {
+		merged := geoPolicy(map[string][]string{"us-east1": {"10.0.0.1", "10.0.0.2"}, "europe-west1": {"10.0.0.3"}})
+		remaining := removeRoutingPolicy(merged, geoPolicy(map[string][]string{"us-east1": {"10.0.0.2"}, "europe-west1": nil}))
+		if !sameJSON(remaining, existing) {
+			t.Fatalf("expected policy %s, received %s", formatRoutingPolicy(existing), formatRoutingPolicy(remaining))
+		}
+		if removeRoutingPolicy(existing, existing) != nil {
+			t.Fatal("expected nothing left after removing the whole policy")
+		}
+	})
+	t.Run("kinds are ignored", func(t *testing.T) {
+		withKinds := geoPolicy(map[string][]string{"us-east1": {"10.0.0.1"}})
+		withKinds.Kind = "dns#rRSetRoutingPolicy"
+		withKinds.Geo.Kind = "dns#rRSetRoutingPolicyGeoPolicy"
+		if !sameJSON(cloneRoutingPolicy(withKinds), existing) {
+			t.Fatal("expected the kinds to be removed from the policy")
+		}
+	})
+}
+
+func Test_RoutingPolicyRecords(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	ctx := context.Background()
+	if err := server.AddRecordSet(testProject, "libdns", &dns.ResourceRecordSet{
+		Name: "geo.libdns.io.", Type: "A", Ttl: 300,
+		RoutingPolicy: geoPolicy(map[string][]string{"us-east1": {"10.0.0.1"}}),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	storedPolicy := func() *dns.RRSetRoutingPolicy {
+		rrs := server.RecordSet(testProject, "libdns", "geo.libdns.io.", "A")
+		if rrs == nil {
+			return nil
+		}
+		if len(rrs.Rrdatas) != 0 {
+			t.Fatal("the record set holds both a routing policy and values")
+		}
+		return cloneRoutingPolicy(rrs.RoutingPolicy)
+	}
+	t.Run("routing policies are read", func(t *testing.T) {
+		records, err := p.GetRecords(ctx, testZone)
+		if err != nil {
+			t.Fatal("error listing records from the test zone:", err)
+		}
+		found := false
+		for _, record := range records {
+			if r, ok := record.(RoutingPolicy); ok && r.Name == "geo" {
+				found = r.TTL == 5*time.Minute && r.RR().Data == "geo us-east1=10.0.0.1"
+			}
+		}
+		if !found {
+			t.Fatal("expected the geo routing policy back")
+		}
+	})
+	t.Run("appended routing policies are merged", func(t *testing.T) {
+		appended := RoutingPolicy{Name: "geo", Type: "A", Policy: geoPolicy(map[string][]string{"europe-west1": {"10.0.0.2"}})}
+		records, err := p.AppendRecords(ctx, testZone, []libdns.Record{appended})
+		if err != nil {
+			t.Fatal("error appending a routing policy:", err)
+		}
+		if len(records) != 1 {
+			t.Fatal("expected one record back, received", len(records))
+		}
+		expected := geoPolicy(map[string][]string{"us-east1": {"10.0.0.1"}, "europe-west1": {"10.0.0.2"}})
+		if policy := storedPolicy(); !sameJSON(policy, expected) {
+			t.Fatalf("expected policy %s, found %s", formatRoutingPolicy(expected), formatRoutingPolicy(policy))
+		}
+	})
+	t.Run("plain values cannot be appended to a routing policy", func(t *testing.T) {
+		_, err := p.AppendRecords(ctx, testZone, []libdns.Record{
+			libdns.RR{Name: "geo", Type: "A", Data: "10.0.0.3"},
+		})
+		if err == nil {
+			t.Fatal("expected an error back but did not receive one")
+		}
+		if policy := storedPolicy(); policy == nil || len(policy.Geo.Items) != 2 {
+			t.Fatal("the routing policy was modified")
+		}
+	})
+	t.Run("plain values do not delete a routing policy", func(t *testing.T) {
+		records, err := p.DeleteRecords(ctx, testZone, []libdns.Record{
+			libdns.RR{Name: "geo", Type: "A", Data: "10.0.0.1"},
+		})
+		if err != nil {
+			t.Fatal("error deleting records:", err)
+		}
+		if len(records) != 0 {
+			t.Fatal("expected no records back, received", len(records))
+		}
+		if policy := storedPolicy(); policy == nil || len(policy.Geo.Items) != 2 {
+			t.Fatal("the routing policy was modified")
+		}
+	})
+	t.Run("plain values do not replace a routing policy", func(t *testing.T) {
+		_, err := p.SetRecords(ctx, testZone, []libdns.Record{
+			libdns.Address{Name: "geo", IP: netip.MustParseAddr("10.9.9.9"), TTL: time.Minute},
+		})
+		if err == nil {
+			t.Fatal("expected an error back but did not receive one")
+		}
+		if policy := storedPolicy(); policy == nil || len(policy.Geo.Items) != 2 {
+			t.Fatal("the routing policy was modified")
+		}
+	})
+	t.Run("deleting a routing policy item keeps the others", func(t *testing.T) {
+		ttl := time.Duration(server.RecordSet(testProject, "libdns", "geo.libdns.io.", "A").Ttl) * time.Second
+		records, err := p.DeleteRecords(ctx, testZone, []libdns.Record{
+			RoutingPolicy{Name: "geo", Type: "A", Policy: geoPolicy(map[string][]string{"us-east1": {"10.0.0.1"}})},
+		})
+		if err != nil {
+			t.Fatal("error deleting a routing policy item:", err)
+		}
+		if len(records) != 1 {
+			t.Fatal("expected one record back, received", len(records))
+		}
+		if rr := records[0].RR(); rr.TTL != ttl || rr.Data != "geo us-east1=10.0.0.1" {
+			t.Fatalf("expected the deleted item with the TTL of the record set %s back, received %+v", ttl, rr)
+		}
+		expected := geoPolicy(map[string][]string{"europe-west1": {"10.0.0.2"}})
+		if policy := storedPolicy(); !sameJSON(policy, expected) {
+			t.Fatalf("expected policy %s, found %s", formatRoutingPolicy(expected), formatRoutingPolicy(policy))
+		}
+	})
+	t.Run("deleting the last item deletes the record set", func(t *testing.T) {
+		_, err := p.DeleteRecords(ctx, testZone, []libdns.Record{
+			RoutingPolicy{Name: "geo", Type: "A", Policy: geoPolicy(map[string][]string{"europe-west1": {"10.0.0.2"}})},
+		})
+		if err != nil {
+			t.Fatal("error deleting a routing policy item:", err)
+		}
+		if storedPolicy() != nil {
+			t.Fatal("expected the record set to be deleted")
+		}
+	})
+}
+
+func Test_SetRoutedRecords(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	ctx := context.Background()
+	if err := server.AddRecordSet(testProject, "libdns", &dns.ResourceRecordSet{
+		Name: "wrr.libdns.io.", Type: "A", Ttl: 300, Rrdatas: []string{"10.0.0.1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("geolocation record sets are created", func(t *testing.T) {
+		record, err := p.SetGeoRecords(ctx, testZone, "geo.libdns.io.", "A", time.Minute, map[string][]string{
+			"us-east1":     {"10.0.0.1", "10.0.0.2"},
+			"europe-west1": {"10.0.0.3"},
+		})
+		if err != nil {
+			t.Fatal("error setting the geolocation records:", err)
+		}
+		if record.Name != "geo" || record.RR().Data != "geo europe-west1=10.0.0.3 us-east1=10.0.0.1,10.0.0.2" {
+			t.Fatalf("unexpected record returned: %+v", record.RR())
+		}
+		rrs := server.RecordSet(testProject, "libdns", "geo.libdns.io.", "A")
+		if rrs == nil || rrs.Ttl != 60 || rrs.RoutingPolicy == nil || len(rrs.RoutingPolicy.Geo.Items) != 2 {
+			t.Fatalf("unexpected record set stored: %+v", rrs)
+		}
+	})
+	t.Run("geolocation record sets are replaced", func(t *testing.T) {
+		if _, err := p.SetGeoRecords(ctx, testZone, "geo", "A", time.Minute, map[string][]string{
+			"asia-east1": {"10.0.0.4"},
+		}); err != nil {
+			t.Fatal("error setting the geolocation records:", err)
+		}
+		rrs := server.RecordSet(testProject, "libdns", "geo.libdns.io.", "A")
+		expected := geoPolicy(map[string][]string{"asia-east1": {"10.0.0.4"}})
+		if rrs == nil || !sameJSON(cloneRoutingPolicy(rrs.RoutingPolicy), expected) {
+			t.Fatalf("unexpected record set stored: %+v", rrs)
+		}
+	})
+	t.Run("plain record sets are replaced by weighted ones", func(t *testing.T) {
+		record, err := p.SetWeightedRecords(ctx, testZone, "wrr", "A", 5*time.Minute, map[float64][]string{
+			0.75: {"10.0.0.1"},
+			0.25: {"10.0.0.2"},
+		})
+		if err != nil {
+			t.Fatal("error setting the weighted records:", err)
+		}
+		if record.RR().Data != "wrr 0.25=10.0.0.2 0.75=10.0.0.1" {
+			t.Fatalf("unexpected record returned: %+v", record.RR())
+		}
+		rrs := server.RecordSet(testProject, "libdns", "wrr.libdns.io.", "A")
+		if rrs == nil || len(rrs.Rrdatas) != 0 || rrs.RoutingPolicy == nil || len(rrs.RoutingPolicy.Wrr.Items) != 2 {
+			t.Fatalf("unexpected record set stored: %+v", rrs)
+		}
+	})
+	t.Run("values are quoted", func(t *testing.T) {
+		if _, err := p.SetWeightedRecords(ctx, testZone, "wrr", "TXT", time.Minute, map[float64][]string{
+			1: {"hello world"},
+		}); err != nil {
+			t.Fatal("error setting the weighted records:", err)
+		}
+		rrs := server.RecordSet(testProject, "libdns", "wrr.libdns.io.", "TXT")
+		if rrs == nil || rrs.RoutingPolicy.Wrr.Items[0].Rrdatas[0] != `"hello world"` {
+			t.Fatalf("unexpected record set stored: %+v", rrs)
+		}
+	})
+	t.Run("policies need items", func(t *testing.T) {
+		if _, err := p.SetGeoRecords(ctx, testZone, "empty", "A", time.Minute, nil); err == nil {
+			t.Fatal("expected an error back but did not receive one")
+		}
+		if _, err := p.SetWeightedRecords(ctx, testZone, "empty", "A", time.Minute, map[float64][]string{-1: {"10.0.0.1"}}); err == nil {
+			t.Fatal("expected an error back but did not receive one")
+		}
+	})
+}
+
+func Test_FailoverRecords(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	ctx := context.Background()
+	failover := Failover{
+		Primary: &dns.RRSetRoutingPolicyHealthCheckTargets{
+			InternalLoadBalancers: []*dns.RRSetRoutingPolicyLoadBalancerTarget{{
+				IpAddress:        "10.128.0.10",
+				IpProtocol:       "tcp",
+				LoadBalancerType: "regionalL4ilb",
+				NetworkUrl:       "https://www.googleapis.com/compute/v1/projects/test-dev/global/networks/default",
+				Port:             "80",
+				Project:          testProject,
+				Region:           "us-east1",
+			}},
+		},
+		Backup: map[string][]string{"us-east1": {"10.0.0.1"}, "europe-west1": {"10.0.0.2"}},
+		BackupTargets: map[string]*dns.RRSetRoutingPolicyHealthCheckTargets{
+			"asia-east1": {ExternalEndpoints: []string{"203.0.113.1"}},
+		},
+		BackupFencing: true,
+		TrickleRatio:  0.1,
+		HealthCheck:   "projects/test-dev/global/healthChecks/libdns",
+	}
+	t.Run("failover record sets are created", func(t *testing.T) {
+		record, err := p.SetFailoverRecords(ctx, testZone, "failover", "A", time.Minute, failover)
+		if err != nil {
+			t.Fatal("error setting the failover records:", err)
+		}
+		expected := "primaryBackup primary=10.128.0.10:80; backup geo fencing asia-east1=203.0.113.1 " +
+			"europe-west1=10.0.0.2 us-east1=10.0.0.1; trickle 0.1"
+		if data := record.RR().Data; data != expected {
+			t.Fatalf("expected data %q, received %q", expected, data)
+		}
+		rrs := server.RecordSet(testProject, "libdns", "failover.libdns.io.", "A")
+		if rrs == nil || rrs.RoutingPolicy == nil || rrs.RoutingPolicy.PrimaryBackup == nil || rrs.RoutingPolicy.HealthCheck == "" {
+			t.Fatalf("unexpected record set stored: %+v", rrs)
+		}
+	})
+	t.Run("failover record sets round trip", func(t *testing.T) {
+		records, err := p.GetRecords(ctx, testZone)
+		if err != nil {
+			t.Fatal("error listing records from the test zone:", err)
+		}
+		var record RoutingPolicy
+		for _, r := range records {
+			if routingPolicy, ok := r.(RoutingPolicy); ok && routingPolicy.Name == "failover" {
+				record = routingPolicy
+			}
+		}
+		received, ok := record.Failover()
+		if !ok {
+			t.Fatal("expected a failover routing policy back")
+		}
+		if !reflect.DeepEqual(received, failover) {
+			t.Fatalf("expected failover %+v, received %+v", failover, received)
+		}
+		changes := len(server.Changes(testProject, "libdns"))
+		if _, err := p.SetRecords(ctx, testZone, []libdns.Record{record}); err != nil {
+			t.Fatal("error setting the failover records:", err)
+		}
+		if len(server.Changes(testProject, "libdns")) != changes {
+			t.Fatal("setting the record read back should not change the zone")
+		}
+	})
+	t.Run("failover policies need targets and a backup", func(t *testing.T) {
+		invalid := []Failover{
+			{Backup: failover.Backup},
+			{Primary: failover.Primary},
+			{Primary: failover.Primary, Backup: failover.Backup, TrickleRatio: 2},
+		}
+		for _, f := range invalid {
+			if _, err := p.SetFailoverRecords(ctx, testZone, "invalid", "A", time.Minute, f); err == nil {
+				t.Fatalf("expected an error back for %+v but did not receive one", f)
+			}
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package googleclouddns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxCharacterStringLength is the longest RFC 1035 character string, longer TXT data is split into
+// several character strings.
+const maxCharacterStringLength = 255
+
+// encodeTXT encodes the text of a TXT record as the RFC 1035 character strings Cloud DNS expects.
+// The text is split into quoted strings of at most 255 bytes, with quotes and backslashes escaped
+// and the bytes that are not printable ASCII written as \DDD. Short text without any special
+// character is returned as is, Cloud DNS quotes it itself.
+func encodeTXT(text string) string {
+	if isPlainCharacterString(text) {
+		return text
+	}
+	chunks := make([]string, 0, len(text)/maxCharacterStringLength+1)
+	for start := 0; start == 0 || start < len(text); start += maxCharacterStringLength {
+		chunks = append(chunks, quoteCharacterString(text[start:min(start+maxCharacterStringLength, len(text))]))
+	}
+	return strings.Join(chunks, " ")
+}
+
+// quoteCharacterString quotes the text as a single character string, with quotes and backslashes
+// escaped and the bytes that are not printable ASCII written as \DDD.
+func quoteCharacterString(text string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range []byte(text) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, `\%03d`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isPlainCharacterString returns true if the text can be used as a single character string without
+// quoting or escaping it.
+func isPlainCharacterString(text string) bool {
+	if len(text) == 0 || len(text) > maxCharacterStringLength {
+		return false
+	}
+	for _, c := range []byte(text) {
+		if c <= ' ' || c > '~' || strings.IndexByte(`"\;()`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeTXT returns the text of the TXT data as stored by Cloud DNS, i.e. its character strings
+// unescaped and joined together.
+func decodeTXT(data string) (string, error) {
+	characterStrings, err := splitTXT(data)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(characterStrings, ""), nil
+}
+
+// splitTXT returns the unescaped RFC 1035 character strings of the TXT data. The strings are
+// separated by whitespace and either quoted or not.
+func splitTXT(data string) ([]string, error) {
+	characterStrings := make([]string, 0)
+	for i := 0; i < len(data); {
+		if data[i] == ' ' || data[i] == '\t' {
+			i++
+			continue
+		}
+		quoted := data[i] == '"'
+		if quoted {
+			i++
+		}
+		var b strings.Builder
+		for {
+			if i >= len(data) {
+				if quoted {
+					return nil, fmt.Errorf("unterminated character string in TXT data %q", data)
+				}
+				break
+			}
+			c := data[i]
+			if quoted && c == '"' {
+				i++
+				break
+			}
+			if !quoted && (c == ' ' || c == '\t' || c == '"') {
+				break
+			}
+			if c != '\\' {
+				b.WriteByte(c)
+				i++
+				continue
+			}
+			n, err := unescapeTXT(data[i:], &b)
+			if err != nil {
+				return nil, fmt.Errorf("invalid escape in TXT data %q: %w", data, err)
+			}
+			i += n
+		}
+		characterStrings = append(characterStrings, b.String())
+	}
+	return characterStrings, nil
+}
+
+// unescapeTXT writes the byte escaped at the start of the data, either \X or \DDD, and returns the
+// length of the escape sequence.
+func unescapeTXT(data string, b *strings.Builder) (int, error) {
+	if len(data) < 2 {
+		return 0, fmt.Errorf("trailing backslash")
+	}
+	if !isDigit(data[1]) {
+		b.WriteByte(data[1])
+		return 2, nil
+	}
+	if len(data) < 4 || !isDigit(data[2]) || !isDigit(data[3]) {
+		return 0, fmt.Errorf("incomplete decimal escape %q", data[:min(len(data), 4)])
+	}
+	value := int(data[1]-'0')*100 + int(data[2]-'0')*10 + int(data[3]-'0')
+	if value > 255 {
+		return 0, fmt.Errorf("decimal escape %q is out of range", data[:4])
+	}
+	b.WriteByte(byte(value))
+	return 4, nil
+}
+
+// isDigit returns true if the byte is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,143 @@
+package googleclouddns
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"google.golang.org/api/dns/v1"
+)
+
+func Test_EncodeTXT(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{`1234567890abcdef`, `1234567890abcdef`},
+		{`v=spf1 -all`, `"v=spf1 -all"`},
+		{``, `""`},
+		{`"quoted"`, `"\"quoted\""`},
+		{`back\slash`, `"back\\slash"`},
+		{`semi;colon`, `"semi;colon"`},
+		{"tab\tand\nnewline", `"tab\009and\010newline"`},
+		{"café", `"caf\195\169"`},
+		{strings.Repeat("a", 255), strings.Repeat("a", 255)},
+		{strings.Repeat("a", 256), `"` + strings.Repeat("a", 255) + `" "a"`},
+		{strings.Repeat(`"`, 256), `"` + strings.Repeat(`\"`, 255) + `" "\""`},
+	}
+	for _, test := range tests {
+		if encoded := encodeTXT(test.text); encoded != test.expected {
+			t.Errorf("expected %q to be encoded as %q, received %q", test.text, test.expected, encoded)
+		}
+	}
+}
+
+func Test_DecodeTXT(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+		valid    bool
+	}{
+		{`"v=spf1 -all"`, `v=spf1 -all`, true},
+		{`unquoted`, `unquoted`, true},
+		{`"v=DKIM1; k=rsa; " "p=MIIBIjANBgkqh"`, `v=DKIM1; k=rsa; p=MIIBIjANBgkqh`, true},
+		{`"a"  "b"	c`, `abc`, true},
+		{`"\"quoted\""`, `"quoted"`, true},
+		{`"back\\slash"`, `back\slash`, true},
+		{`"caf\195\169"`, "café", true},
+		{`""`, ``, true},
+		{``, ``, true},
+		{`"unterminated`, ``, false},
+		{`"trailing\`, ``, false},
+		{`"\25"`, ``, false},
+		{`"\256"`, ``, false},
+	}
+	for _, test := range tests {
+		text, err := decodeTXT(test.data)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("expected an error decoding %q, received %q", test.data, text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("error decoding %q: %v", test.data, err)
+		} else if text != test.expected {
+			t.Errorf("expected %q to be decoded as %q, received %q", test.data, test.expected, text)
+		}
+	}
+}
+
+func Test_TXTRecords(t *testing.T) {
+	p, server := getFakeDNSClient(t)
+	ctx := context.Background()
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8A", 12)
+	records := []libdns.Record{
+		libdns.TXT{Name: "dkim._domainkey", Text: dkim, TTL: time.Minute},
+		libdns.TXT{Name: "quotes", Text: `say "hello" \ goodbye`, TTL: time.Minute},
+	}
+	if _, err := p.AppendRecords(ctx, testZone, records); err != nil {
+		t.Fatal("error appending records:", err)
+	}
+	rrs := server.RecordSet(testProject, "libdns", "dkim._domainkey."+testZone, "TXT")
+	if rrs == nil || strings.Count(rrs.Rrdatas[0], `" "`) != 1 {
+		t.Fatal("expected the DKIM key to be split into two character strings, found", rrs)
+	}
+	fetched, err := p.GetRecords(ctx, testZone)
+	if err != nil {
+		t.Fatal("error listing records:", err)
+	}
+	compareTestData(records, fetched, t)
+	if _, err := p.DeleteRecords(ctx, testZone, records); err != nil {
+		t.Fatal("error deleting records:", err)
+	}
+	if server.RecordSet(testProject, "libdns", "quotes."+testZone, "TXT") != nil {
+		t.Fatal("expected the record with quotes to be deleted")
+	}
+}
+
+func Test_ConvertTXTWarnings(t *testing.T) {
+	rrs := &dns.ResourceRecordSet{Name: "broken." + testZone, Type: "TXT", Ttl: 60, Rrdatas: []string{`"unterminated`}}
+	records, warnings := convertToLibDNS(rrs, testZone)
+	if len(warnings) != 1 || len(records) != 1 || records[0].RR().Data != `"unterminated` {
+		t.Fatalf("expected the raw value back with a warning, received %v and %v", records, warnings)
+	}
+}
+
+func FuzzTXTRoundTrip(f *testing.F) {
+	for _, seed := range []string{"", "challenge", "v=spf1 -all", `"\;()`, "\x00\xff", strings.Repeat("x", 600)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		characterStrings, err := splitTXT(encodeTXT(text))
+		if err != nil {
+			t.Fatalf("error decoding the encoded %q: %v", text, err)
+		}
+		for _, characterString := range characterStrings {
+			if len(characterString) > maxCharacterStringLength {
+				t.Fatalf("character string of %d bytes encoded from %q", len(characterString), text)
+			}
+		}
+		if decoded := strings.Join(characterStrings, ""); decoded != text {
+			t.Fatalf("expected %q back, received %q", text, decoded)
+		}
+	})
+}
+
+func FuzzDecodeTXT(f *testing.F) {
+	for _, seed := range []string{`"a" "b"`, `unquoted`, `"\"\\\065"`, `"unterminated`, `\`, `"\2"`} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data string) {
+		text, err := decodeTXT(data)
+		if err != nil {
+			return
+		}
+		again, err := decodeTXT(encodeTXT(text))
+		if err != nil || again != text {
+			t.Fatalf("expected %q to survive encoding, received %q (%v)", text, again, err)
+		}
+	})
+}
@@ -18,80 +18,199 @@ type dnsMetadata struct {
 	recordType string
 }
 
+// recordGroup is a set of libdns.Records sharing the same name and type, which maps
+// to a single Cloud DNS record set.
+type recordGroup struct {
+	dnsMetadata
+	records libdnsRecords
+}
+
 // groupRecordsByType groups libdns.Record entries by name and type to ensure multiple
-// values are sent at the same time to Google Cloud DNS.
-func (l libdnsRecords) groupRecordsByType() map[dnsMetadata]libdnsRecords {
-	gdrs := make(map[dnsMetadata]libdnsRecords)
+// values are sent at the same time to Google Cloud DNS. Groups are returned in the order
+// their first record appears so that the resulting change is deterministic.
+func (l libdnsRecords) groupRecordsByType() []recordGroup {
+	gdrs := make([]recordGroup, 0)
+	index := make(map[dnsMetadata]int)
 	for _, record := range l {
 		dnsRecord := dnsMetadata{
 			name:       record.RR().Name,
 			recordType: record.RR().Type,
 		}
-		if gdr, ok := gdrs[dnsRecord]; !ok {
-			gdrs[dnsRecord] = libdnsRecords{record}
-		} else {
-			gdrs[dnsRecord] = append(gdr, record)
+		if i, ok := index[dnsRecord]; ok {
+			gdrs[i].records = append(gdrs[i].records, record)
+			continue
 		}
+		index[dnsRecord] = len(gdrs)
+		gdrs = append(gdrs, recordGroup{dnsMetadata: dnsRecord, records: libdnsRecords{record}})
 	}
 	return gdrs
 }
 
 // hasRecord returns if this set of records contains the specified record. Only the name,
-// type, and data/value are compared; the TTL is ignored.
+// type, and data/value are compared; the TTL is ignored. A RoutingPolicy is contained if all
+// its items are part of a routing policy in this set.
 func (l libdnsRecords) hasRecord(record libdns.Record) bool {
 	rr := record.RR()
+	routingPolicy, isRoutingPolicy := record.(RoutingPolicy)
 	for _, existingRecord := range l {
 		er := existingRecord.RR()
-		if rr.Name == er.Name && rr.Type == er.Type && rr.Data == er.Data {
+		if rr.Name != er.Name || rr.Type != er.Type {
+			continue
+		}
+		if existingRoutingPolicy, ok := existingRecord.(RoutingPolicy); ok && isRoutingPolicy {
+			if containsRoutingPolicy(existingRoutingPolicy.Policy, routingPolicy.Policy) {
+				return true
+			}
+			continue
+		}
+		if sameRdata(rr.Type, rr.Data, er.Data) {
 			return true
 		}
 	}
 	return false
 }
 
+// matchingRecords returns the records of this set matched by the record to delete, following the
+// libdns rules for DeleteRecords: the name must be the same, while an empty type, a zero TTL or
+// empty data match any type, TTL or data. A RoutingPolicy matches if all its items are part of a
+// routing policy in this set, and the existing record is returned holding only those items, as only
+// they are deleted.
+func (l libdnsRecords) matchingRecords(record libdns.Record) libdnsRecords {
+	rr := record.RR()
+	routingPolicy, isRoutingPolicy := record.(RoutingPolicy)
+	matches := make(libdnsRecords, 0)
+	for _, existingRecord := range l {
+		er := existingRecord.RR()
+		if rr.Name != er.Name || (rr.Type != "" && rr.Type != er.Type) || (rr.TTL != 0 && rr.TTL/time.Second != er.TTL/time.Second) {
+			continue
+		}
+		if existingRoutingPolicy, ok := existingRecord.(RoutingPolicy); ok && isRoutingPolicy {
+			if containsRoutingPolicy(existingRoutingPolicy.Policy, routingPolicy.Policy) {
+				existingRoutingPolicy.Policy = routingPolicy.Policy
+				matches = append(matches, existingRoutingPolicy)
+			}
+			continue
+		}
+		if rr.Data == "" || sameRdata(er.Type, rr.Data, er.Data) {
+			matches = append(matches, existingRecord)
+		}
+	}
+	return matches
+}
+
 // doesNotHaveRecords returns true if this set of records does not contain the specified
 // record. Only the name, type, and value are compared; the TTL is ignored.
 func (l libdnsRecords) doesNotHaveRecord(record libdns.Record) bool {
 	return !l.hasRecord(record)
 }
 
+// isEquivalent returns true if both sets of records hold the same values with the same TTL,
+// regardless of order.
+func (l libdnsRecords) isEquivalent(other libdnsRecords) bool {
+	if len(l) != len(other) {
+		return false
+	}
+	for i, record := range l {
+		if other.doesNotHaveRecord(record) || l.doesNotHaveRecord(other[i]) || record.RR().TTL != other[0].RR().TTL {
+			return false
+		}
+	}
+	return true
+}
+
 // prepValuesForCloudDNS returns a slice of strings containing the values from this set of
-// records. Note that if the value contains spaces, this will add quotes to ensure
-// it is properly populated in Cloud DNS.
+// records, encoded the way Cloud DNS stores them for their type.
 func (l libdnsRecords) prepValuesForCloudDNS() []string {
 	values := make([]string, 0)
 	for _, record := range l {
-		value := record.RR().Data
-		if strings.Contains(value, " ") {
-			//ensure we quote a value with spaces but do not double quote
-			value = fmt.Sprintf(`"%s"`, strings.Trim(value, `"`))
-		}
-		values = append(values, value)
-
+		rr := record.RR()
+		values = append(values, encodeRdata(rr.Type, rr.Data))
 	}
 	return values
 }
 
+// toResourceRecordSet builds the Cloud DNS record set for this set of records. All records
+// are expected to share the same name and type; the TTL of the first record is used.
+func (l libdnsRecords) toResourceRecordSet(zone string) (*dns.ResourceRecordSet, error) {
+	rr := l[0].RR()
+	rrs := &dns.ResourceRecordSet{
+		Name: libdns.AbsoluteName(rr.Name, zone),
+		Ttl:  int64(rr.TTL / time.Second),
+		Type: rr.Type,
+	}
+	routingPolicy, err := l.routingPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if routingPolicy != nil {
+		rrs.RoutingPolicy = routingPolicy
+	} else {
+		rrs.Rrdatas = l.prepValuesForCloudDNS()
+	}
+	return rrs, nil
+}
+
+// longestZoneSuffix returns the most specific zone in the zone map that contains the specified FQDN.
+func longestZoneSuffix(fqdn string, zones map[string]string) (string, bool) {
+	name := fqdn
+	for {
+		if _, ok := zones[name]; ok {
+			return name, true
+		}
+		i := strings.Index(name, ".")
+		if i < 0 || i == len(name)-1 {
+			return "", false
+		}
+		name = name[i+1:]
+	}
+}
+
+// isInZone returns true if the specified FQDN is the zone apex or one of its subdomains.
+func isInZone(fqdn, zone string) bool {
+	return fqdn == zone || strings.HasSuffix(fqdn, "."+zone)
+}
+
 // convertToLibDNS takes Cloud DNS record set and converts it into a set of libdns
-// records. Note that this will remove the quotes around a value.
-func convertToLibDNS(googleRecord *dns.ResourceRecordSet, zone string) (libdnsRecords, error) {
+// records. Note that this will decode the values from the way Cloud DNS stores them,
+// e.g. unquote TXT values. Record sets with a routing policy are returned as a single RoutingPolicy
+// record. Values that libdns cannot parse are returned as a libdns.RR along with a
+// warning for each of them.
+func convertToLibDNS(googleRecord *dns.ResourceRecordSet, zone string) (libdnsRecords, []error) {
 	records := make([]libdns.Record, 0)
+	warnings := make([]error, 0)
+	if googleRecord.RoutingPolicy != nil { // the values live in the routing policy
+		records = append(records, RoutingPolicy{
+			Name:   libdns.RelativeName(googleRecord.Name, zone),
+			Type:   googleRecord.Type,
+			TTL:    time.Duration(googleRecord.Ttl) * time.Second,
+			Policy: cloneRoutingPolicy(googleRecord.RoutingPolicy),
+		})
+		return records, warnings
+	}
+	if len(googleRecord.Rrdatas) == 0 {
+		warnings = append(warnings, fmt.Errorf("record set of type '%s' has no values", googleRecord.Type))
+	}
 	for _, value := range googleRecord.Rrdatas {
 		// there can be multiple values per record  so
 		// let's treat each one as a separate libdns Record
 
-		record, err := libdns.RR{
+		data, err := decodeRdata(googleRecord.Type, value)
+		rr := libdns.RR{
 			Type: googleRecord.Type,
 			Name: libdns.RelativeName(googleRecord.Name, zone),
-			Data: strings.Trim(value, `"`),
+			Data: data,
 			TTL:  time.Duration(googleRecord.Ttl) * time.Second,
-		}.Parse()
-
+		}
+		var record libdns.Record = rr
+		if err == nil {
+			record, err = parseRdata(rr)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error parsing record of type '%s': %w", googleRecord.Type, err)
+			warnings = append(warnings, fmt.Errorf("error parsing record of type '%s': %w", googleRecord.Type, err))
+			record = rr
 		}
 
 		records = append(records, record)
 	}
-	return records, nil
+	return records, warnings
 }
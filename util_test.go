@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"cloud.google.com/go/httpreplay"
+	"github.com/libdns/googleclouddns/googleclouddnstest"
 	"github.com/libdns/libdns"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/dns/v1"
@@ -32,6 +33,29 @@ func compareTestData(test, results []libdns.Record, t *testing.T) {
 	}
 }
 
+// getFakeDNSClient returns a Provider for the test project using an in-memory Cloud DNS
+// server that holds the test zone.
+func getFakeDNSClient(t *testing.T) (*Provider, *googleclouddnstest.Server) {
+	server := googleclouddnstest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddZone(testProject, &dns.ManagedZone{Name: "libdns", DnsName: testZone})
+	provider := Provider{
+		Project:    testProject,
+		Endpoint:   server.Endpoint(),
+		HTTPClient: server.Client(),
+	}
+	return &provider, server
+}
+
+// getFakeZonesDNSClient returns a fake Provider whose project also holds a private zone for the
+// test zone, making it a split horizon zone, and a private zone for a subdomain of the test zone.
+func getFakeZonesDNSClient(t *testing.T) (*Provider, *googleclouddnstest.Server) {
+	p, server := getFakeDNSClient(t)
+	server.AddZone(testProject, &dns.ManagedZone{Name: "libdns-private", DnsName: testZone, Visibility: VisibilityPrivate})
+	server.AddZone(testProject, &dns.ManagedZone{Name: "libdns-internal", DnsName: "internal." + testZone, Visibility: VisibilityPrivate})
+	return p, server
+}
+
 type replayClose interface {
 	Close() error
 }
@@ -0,0 +1,143 @@
+package googleclouddns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"google.golang.org/api/dns/v1"
+)
+
+const (
+	// defaultZoneCacheTTL timeout for the Google Cloud DNS zone map if ZoneCacheTTL is not set
+	defaultZoneCacheTTL = time.Minute * 5
+)
+
+// zoneRefreshInterval is the shortest time between two listings of the managed zones of a project
+// when a zone is not found in the cache.
+var zoneRefreshInterval = time.Second * 10
+
+// sharedZoneCache holds the managed zones of the providers with ShareZoneCache set.
+var sharedZoneCache = &zoneCache{}
+
+// zoneCache holds the managed zones listed per project, keyed by the endpoint, project and credentials
+// they were listed with.
+type zoneCache struct {
+	mutex    sync.Mutex
+	projects map[string]*cachedZones
+}
+
+// cachedZones are the managed zones of a project as they were last listed. While the zones are listed
+// again, listing is closed once the listing is over. The generation counts the invalidations, so a
+// listing started before an invalidation does not cache the zones it listed.
+type cachedZones struct {
+	mutex       sync.Mutex
+	zones       []*dns.ManagedZone
+	lastUpdated time.Time
+	listing     chan struct{}
+	generation  uint64
+}
+
+// InvalidateZoneCache forgets the managed zones listed so far, so they are listed again the next time
+// a zone is looked up. If ShareZoneCache is set, the zones are forgotten by every provider sharing
+// the cache for the same project.
+func (p *Provider) InvalidateZoneCache() {
+	cached := p.getCachedZones()
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+	cached.zones = nil
+	cached.lastUpdated = time.Time{}
+	cached.generation++
+}
+
+// forgetDeletedZone invalidates the cached managed zones if the error reports the managed zone of the
+// call as missing, so a managed zone deleted after being cached is not used again. It returns the
+// error as is.
+func (p *Provider) forgetDeletedZone(err error) error {
+	if isManagedZoneNotFoundError(err) {
+		p.InvalidateZoneCache()
+	}
+	return err
+}
+
+// getCachedZones returns the cached managed zones of the project of the provider.
+func (p *Provider) getCachedZones() *cachedZones {
+	cache := &p.zoneCache
+	if p.ShareZoneCache {
+		cache = sharedZoneCache
+	}
+	key := p.zoneCacheKey()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.projects == nil {
+		cache.projects = make(map[string]*cachedZones)
+	}
+	cached, ok := cache.projects[key]
+	if !ok {
+		cached = &cachedZones{}
+		cache.projects[key] = cached
+	}
+	return cached
+}
+
+// zoneCacheKey identifies the endpoint, project and credentials of the provider, as a provider may
+// not see the managed zones listed with other credentials. The key is hashed so the cache does not
+// hold on to inline service account JSON.
+func (p *Provider) zoneCacheKey() string {
+	hash := sha256.New()
+	for _, part := range append([]string{p.Endpoint, p.Project, p.ServiceAccountJSON, p.ImpersonateServiceAccount}, p.ImpersonateDelegates...) {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// loadCloudDNSZones returns the managed zones of the project, listing them if they were not cached
+// yet or are older than the ZoneCacheTTL. When a zone was missed in the cached zones, they are listed
+// again unless they were listed in the last zoneRefreshInterval, so unknown zones do not flood the
+// GCP API servers. The cache is not locked while the zones are listed, and the calls that need the
+// zones in the meantime wait for that listing rather than making their own. If the cache is
+// invalidated during the listing, the zones listed are dropped and listed again.
+func (p *Provider) loadCloudDNSZones(ctx context.Context, missed bool) ([]*dns.ManagedZone, error) {
+	ttl := p.ZoneCacheTTL
+	if ttl == 0 {
+		ttl = defaultZoneCacheTTL
+	}
+	cached := p.getCachedZones()
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+	for {
+		age := time.Since(cached.lastUpdated)
+		if cached.zones != nil && ((missed && age <= zoneRefreshInterval) || (!missed && age <= ttl)) {
+			return cached.zones, nil
+		}
+		if listing := cached.listing; listing != nil {
+			cached.mutex.Unlock()
+			select {
+			case <-listing: // the zones listed in the meantime may be recent enough
+			case <-ctx.Done():
+				cached.mutex.Lock()
+				return nil, ctx.Err()
+			}
+			cached.mutex.Lock()
+			continue
+		}
+		listing, generation := make(chan struct{}), cached.generation
+		cached.listing = listing
+		cached.mutex.Unlock()
+		zones, err := p.listAllCloudDNSZones(ctx)
+		cached.mutex.Lock()
+		cached.listing = nil
+		close(listing)
+		if err != nil {
+			return nil, err
+		}
+		if cached.generation == generation {
+			cached.zones = zones
+			cached.lastUpdated = time.Now()
+			return zones, nil
+		}
+	}
+}
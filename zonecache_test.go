@@ -0,0 +1,286 @@
+package googleclouddns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libdns/googleclouddns/googleclouddnstest"
+	"github.com/libdns/libdns"
+	"google.golang.org/api/dns/v1"
+)
+
+// listingTransport counts the listings of the managed zones sent to the server. If released is set,
+// the responses to the listings are held until it is closed, and blocked is closed once a response is
+// held.
+type listingTransport struct {
+	base     http.RoundTripper
+	listings atomic.Int32
+	once     sync.Once
+	blocked  chan struct{}
+	released chan struct{}
+}
+
+func (l *listingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/managedZones") {
+		l.listings.Add(1)
+		if l.released != nil {
+			defer func() {
+				l.once.Do(func() { close(l.blocked) })
+				<-l.released
+			}()
+		}
+	}
+	return l.base.RoundTrip(r)
+}
+
+// getCountingDNSClient returns a fake Provider counting the listings of the managed zones.
+func getCountingDNSClient(t *testing.T) (*Provider, *googleclouddnstest.Server, *listingTransport) {
+	p, server := getFakeDNSClient(t)
+	transport := &listingTransport{base: server.Client().Transport}
+	p.HTTPClient = &http.Client{Transport: transport}
+	return p, server, transport
+}
+
+func Test_ZoneCache(t *testing.T) {
+	ctx := context.Background()
+	t.Run("managed zones are cached", func(t *testing.T) {
+		p, _, transport := getCountingDNSClient(t)
+		for range 3 {
+			if _, err := p.GetRecords(ctx, testZone); err != nil {
+				t.Fatal("error listing records:", err)
+			}
+		}
+		if listings := transport.listings.Load(); listings != 1 {
+			t.Fatal("expected the managed zones to be listed once, listed", listings)
+		}
+	})
+	t.Run("new zones are found without waiting for the cache to expire", func(t *testing.T) {
+		p, server, transport := getCountingDNSClient(t)
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		server.AddZone(testProject, &dns.ManagedZone{Name: "new", DnsName: "new.io."})
+		refreshInterval := zoneRefreshInterval
+		zoneRefreshInterval = 0
+		t.Cleanup(func() {
+			zoneRefreshInterval = refreshInterval
+		})
+		if _, err := p.GetRecords(ctx, "new.io."); err != nil {
+			t.Fatal("error listing records from the new zone:", err)
+		}
+		if listings := transport.listings.Load(); listings != 2 {
+			t.Fatal("expected the managed zones to be listed twice, listed", listings)
+		}
+	})
+	t.Run("missing zones are not listed more than once per interval", func(t *testing.T) {
+		p, _, transport := getCountingDNSClient(t)
+		for range 3 {
+			if _, err := p.GetRecords(ctx, "missing.io."); err == nil {
+				t.Fatal("expected an error back but did not receive one")
+			}
+		}
+		if listings := transport.listings.Load(); listings != 1 {
+			t.Fatal("expected the managed zones to be listed once, listed", listings)
+		}
+	})
+	t.Run("managed zones are not cached with a negative TTL", func(t *testing.T) {
+		p, _, transport := getCountingDNSClient(t)
+		p.ZoneCacheTTL = -1
+		for range 3 {
+			if _, err := p.GetRecords(ctx, testZone); err != nil {
+				t.Fatal("error listing records:", err)
+			}
+		}
+		if listings := transport.listings.Load(); listings != 3 {
+			t.Fatal("expected the managed zones to be listed three times, listed", listings)
+		}
+	})
+	t.Run("a change lists the managed zones once", func(t *testing.T) {
+		p, _, transport := getCountingDNSClient(t)
+		p.ZoneCacheTTL = -1
+		var records []libdns.Record
+		for i := range 10 {
+			name := fmt.Sprintf("change-%d", i)
+			records = append(records, libdns.RR{Name: name, Type: "TXT", TTL: time.Minute, Data: `"value"`},
+				libdns.RR{Name: name, Type: "A", TTL: time.Minute, Data: "127.0.0.1"})
+		}
+		if _, err := p.AppendRecords(ctx, testZone, records); err != nil {
+			t.Fatal("error appending records:", err)
+		}
+		if _, err := p.DeleteRecords(ctx, testZone, []libdns.Record{libdns.RR{Name: "change-0"}}); err != nil {
+			t.Fatal("error deleting records:", err)
+		}
+		if listings := transport.listings.Load(); listings != 2 {
+			t.Fatal("expected the managed zones to be listed once per change, listed", listings)
+		}
+	})
+	t.Run("managed zones are listed again after the TTL", func(t *testing.T) {
+		p, _, transport := getCountingDNSClient(t)
+		p.ZoneCacheTTL = 20 * time.Millisecond
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		time.Sleep(30 * time.Millisecond)
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		if listings := transport.listings.Load(); listings != 2 {
+			t.Fatal("expected the managed zones to be listed twice, listed", listings)
+		}
+	})
+	t.Run("invalidated zones are listed again", func(t *testing.T) {
+		p, _, transport := getCountingDNSClient(t)
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		p.InvalidateZoneCache()
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		if listings := transport.listings.Load(); listings != 2 {
+			t.Fatal("expected the managed zones to be listed twice, listed", listings)
+		}
+	})
+	t.Run("shared zones are listed once for the project", func(t *testing.T) {
+		p, server, transport := getCountingDNSClient(t)
+		p.ShareZoneCache = true
+		other := &Provider{
+			Project:        testProject,
+			Endpoint:       server.Endpoint(),
+			HTTPClient:     p.HTTPClient,
+			ShareZoneCache: true,
+		}
+		for _, provider := range []*Provider{p, other} {
+			if _, err := provider.GetRecords(ctx, testZone); err != nil {
+				t.Fatal("error listing records:", err)
+			}
+		}
+		if listings := transport.listings.Load(); listings != 1 {
+			t.Fatal("expected the managed zones to be listed once, listed", listings)
+		}
+		other.InvalidateZoneCache()
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		if listings := transport.listings.Load(); listings != 2 {
+			t.Fatal("expected the shared zones to be invalidated, listed", listings)
+		}
+	})
+	t.Run("shared zones are kept per credentials", func(t *testing.T) {
+		p := &Provider{Project: testProject, ShareZoneCache: true}
+		for _, other := range []*Provider{
+			{Project: testProject, ShareZoneCache: true, ServiceAccountJSON: "./other.json"},
+			{Project: testProject, ShareZoneCache: true, ImpersonateServiceAccount: "dns@test-dev.iam.gserviceaccount.com"},
+			{Project: testProject, ShareZoneCache: true, ImpersonateServiceAccount: "dns@test-dev.iam.gserviceaccount.com",
+				ImpersonateDelegates: []string{"delegate@test-dev.iam.gserviceaccount.com"}},
+		} {
+			if p.getCachedZones() == other.getCachedZones() {
+				t.Fatalf("expected the zones listed with other credentials to be cached apart, %+v", other)
+			}
+		}
+		same := &Provider{Project: testProject, ShareZoneCache: true}
+		if p.getCachedZones() != same.getCachedZones() {
+			t.Fatal("expected the zones listed with the same credentials to be shared")
+		}
+	})
+	t.Run("cached zones are found while the zones are listed again", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		p.ShareZoneCache = true
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		refreshInterval := zoneRefreshInterval
+		zoneRefreshInterval = 0
+		t.Cleanup(func() {
+			zoneRefreshInterval = refreshInterval
+		})
+		transport := &listingTransport{
+			base:     server.Client().Transport,
+			blocked:  make(chan struct{}),
+			released: make(chan struct{}),
+		}
+		slow := &Provider{
+			Project:        testProject,
+			Endpoint:       p.Endpoint,
+			HTTPClient:     &http.Client{Transport: transport},
+			ShareZoneCache: true,
+		}
+		missing := make(chan error)
+		go func() {
+			_, err := slow.GetRecords(ctx, "missing.io.")
+			missing <- err
+		}()
+		<-transport.blocked // the zones are now being listed again
+		done := make(chan error)
+		go func() {
+			_, err := p.GetRecords(ctx, testZone)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal("error listing records:", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the cached zones were blocked by the listing")
+		}
+		close(transport.released)
+		if err := <-missing; err == nil {
+			t.Fatal("expected an error back for the missing zone but did not receive one")
+		}
+	})
+	t.Run("zones listed before an invalidation are not cached", func(t *testing.T) {
+		p, server := getFakeDNSClient(t)
+		transport := &listingTransport{
+			base:     server.Client().Transport,
+			blocked:  make(chan struct{}),
+			released: make(chan struct{}),
+		}
+		p.HTTPClient = &http.Client{Transport: transport}
+		done := make(chan error)
+		go func() {
+			_, err := p.GetRecords(ctx, testZone)
+			done <- err
+		}()
+		<-transport.blocked // the zones are listed, but not cached yet
+		server.AddZone(testProject, &dns.ManagedZone{Name: "new", DnsName: "new.io."})
+		p.InvalidateZoneCache()
+		close(transport.released)
+		if err := <-done; err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		if _, err := p.GetRecords(ctx, "new.io."); err != nil {
+			t.Fatal("error listing records from the new zone:", err)
+		}
+		if listings := transport.listings.Load(); listings != 2 {
+			t.Fatal("expected the managed zones to be listed again after the invalidation, listed", listings)
+		}
+	})
+	t.Run("zone visibility is applied per provider", func(t *testing.T) {
+		p, server, _ := getCountingDNSClient(t)
+		server.AddZone(testProject, &dns.ManagedZone{Name: "internal", DnsName: "internal.io.", Visibility: VisibilityPrivate})
+		p.ShareZoneCache = true
+		private := &Provider{
+			Project:        testProject,
+			Endpoint:       server.Endpoint(),
+			HTTPClient:     p.HTTPClient,
+			ShareZoneCache: true,
+			ZoneVisibility: VisibilityPrivate,
+		}
+		if _, err := p.GetRecords(ctx, testZone); err != nil {
+			t.Fatal("error listing records:", err)
+		}
+		if _, err := p.GetRecords(ctx, "internal.io."); err == nil {
+			t.Fatal("expected the private zone to be ignored by the public provider")
+		}
+		if _, err := private.GetRecords(ctx, "internal.io."); err != nil {
+			t.Fatal("error listing records from the private zone:", err)
+		}
+	})
+}